@@ -0,0 +1,178 @@
+// Copyright 2020 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blueprint
+
+import (
+	"fmt"
+	"strings"
+	"sync/atomic"
+)
+
+// An alias module forwards its name to another module, which allows a module to be renamed
+// without updating all of the modules that depend on it at once:
+//
+//	my_alias {
+//	    name: "libfoo",
+//	    actual: "libfoo_v2",
+//	    actual_variations: ["arch:arm"],
+//	}
+//
+// A dependency on an alias module by name is replaced with a dependency on the variant of the
+// actual module that would have been chosen for a dependency on the actual module by name.  If
+// actual_variations is set, the variations it lists are used to choose the variant instead, as if
+// AddVariationDependencies had been called with them.  Alias modules can forward to other alias
+// modules.
+//
+// Alias modules appear in the module graph with a dependency on the module named by actual, which
+// may itself be an alias module, so a missing actual module or a cycle of aliases is reported as
+// an error on the alias module.  They do not generate any build actions.  The alias module type is
+// registered with any name by calling Context.RegisterModuleType with AliasModuleFactory.
+type aliasModule struct {
+	SimpleName
+	properties struct {
+		// Actual is the name of the module the alias forwards to.
+		Actual string
+
+		// Actual_variations lists the variations of the actual module that the alias forwards
+		// to, each in the form "mutator:variation".
+		Actual_variations []string
+	}
+}
+
+// AliasModuleFactory is the ModuleFactory for alias modules.
+func AliasModuleFactory() (Module, []interface{}) {
+	m := &aliasModule{}
+	return m, []interface{}{&m.properties, &m.SimpleName.Properties}
+}
+
+func (m *aliasModule) GenerateBuildActions(ModuleContext) {
+}
+
+// variations returns the variations listed in the actual_variations property, skipping any that
+// are malformed.
+func (m *aliasModule) variations() (variations []Variation, invalid []string) {
+	for _, v := range m.properties.Actual_variations {
+		i := strings.Index(v, ":")
+		if i <= 0 {
+			invalid = append(invalid, v)
+			continue
+		}
+		variations = append(variations, Variation{Mutator: v[:i], Variation: v[i+1:]})
+	}
+	return variations, invalid
+}
+
+type aliasDependencyTag struct {
+	BaseDependencyTag
+}
+
+var aliasDepTag aliasDependencyTag
+
+// aliasDepsMutator adds the dependency of each alias module on the module named by its actual
+// property.
+func aliasDepsMutator(ctx BottomUpMutatorContext) {
+	m, ok := ctx.Module().(*aliasModule)
+	if !ok {
+		return
+	}
+
+	if m.properties.Actual == "" {
+		ctx.PropertyErrorf("actual", "alias must set actual")
+		return
+	}
+
+	_, invalid := m.variations()
+	for _, v := range invalid {
+		ctx.PropertyErrorf("actual_variations", "invalid variation %q, expected \"mutator:variation\"", v)
+	}
+	if len(invalid) > 0 {
+		return
+	}
+
+	mctx := ctx.(*mutatorContext)
+	errs := mctx.context.addAliasDependency(mctx.module, m.properties.Actual)
+	mctx.errs = append(mctx.errs, errs...)
+}
+
+// addAliasDependency adds a dependency from the alias module on the module named actual without
+// forwarding through actual if it is also an alias, as the variants selected by the aliases may
+// not have been created yet.
+func (c *Context) addAliasDependency(module *moduleInfo, actual string) []error {
+	target := c.moduleGroupFromName(actual, module.namespace())
+	if target == nil {
+		return c.discoveredMissingDependencies(module, actual)
+	}
+
+	dep := c.findMatchingVariant(module, target, false)
+	if dep == nil {
+		dep = target.modules[0]
+	}
+
+	if dep == module {
+		return []error{&BlueprintError{
+			Err: fmt.Errorf("alias %q forwards to itself", module.Name()),
+			Pos: module.pos,
+		}}
+	}
+
+	module.newDirectDeps = append(module.newDirectDeps, depInfo{dep, aliasDepTag})
+	atomic.AddUint32(&c.depsModified, 1)
+	return nil
+}
+
+// resolveAliases follows any alias modules starting with group to the group of the module they
+// forward to, and returns it along with the variations selected by the aliases.  If the module
+// that an alias forwards to does not exist it returns nil and any errors.
+func (c *Context) resolveAliases(module *moduleInfo, group *moduleGroup,
+	depName string) (*moduleGroup, []Variation, []error) {
+
+	var variations []Variation
+	seen := make(map[*moduleGroup]bool)
+
+	for {
+		if len(group.modules) == 0 {
+			return group, variations, nil
+		}
+		alias, ok := group.modules[0].logicModule.(*aliasModule)
+		if !ok {
+			return group, variations, nil
+		}
+
+		if seen[group] {
+			return nil, nil, []error{&BlueprintError{
+				Err: fmt.Errorf("dependency %q of %q is an alias that forwards to itself",
+					depName, module.Name()),
+				Pos: module.pos,
+			}}
+		}
+		seen[group] = true
+
+		aliasVariations, _ := alias.variations()
+		variations = append(variations, aliasVariations...)
+
+		target := c.moduleGroupFromName(alias.properties.Actual, group.namespace)
+		if target == nil {
+			if c.allowMissingDependencies {
+				return nil, nil, c.discoveredMissingDependencies(module, alias.properties.Actual)
+			}
+			return nil, nil, []error{&BlueprintError{
+				Err: fmt.Errorf("dependency %q of %q is an alias of undefined module %q",
+					depName, module.Name(), alias.properties.Actual),
+				Pos: module.pos,
+			}}
+		}
+		group = target
+	}
+}
@@ -0,0 +1,152 @@
+// Copyright 2020 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blueprint
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestAliasModules(t *testing.T) {
+	testCases := []struct {
+		name string
+		bp   string
+		deps map[string][]string
+		errs []string
+	}{
+		{
+			name: "forward",
+			bp: `
+				foo_module {
+					name: "A",
+					deps: ["libfoo", "libfoo_b", "libfoo_old"],
+				}
+
+				foo_module {
+					name: "libfoo_v2",
+				}
+
+				alias {
+					name: "libfoo",
+					actual: "libfoo_v2",
+				}
+
+				alias {
+					name: "libfoo_b",
+					actual: "libfoo_v2",
+					actual_variations: ["split:b"],
+				}
+
+				alias {
+					name: "libfoo_old",
+					actual: "libfoo",
+				}
+			`,
+			deps: map[string][]string{
+				"A a":         {"libfoo_v2 a", "libfoo_v2 b", "libfoo_v2 a"},
+				"A b":         {"libfoo_v2 b", "libfoo_v2 b", "libfoo_v2 b"},
+				"libfoo ":     {"libfoo_v2 a"},
+				"libfoo_b ":   {"libfoo_v2 a"},
+				"libfoo_old ": {"libfoo "},
+			},
+		},
+		{
+			name: "undefined",
+			bp: `
+				foo_module {
+					name: "A",
+					deps: ["libfoo"],
+				}
+
+				alias {
+					name: "libfoo",
+					actual: "libfoo_v2",
+				}
+			`,
+			errs: []string{
+				`Blueprints:7:5: "libfoo" depends on undefined module "libfoo_v2"`,
+			},
+		},
+		{
+			name: "self",
+			bp: `
+				alias {
+					name: "libfoo",
+					actual: "libfoo",
+				}
+			`,
+			errs: []string{
+				`Blueprints:2:5: alias "libfoo" forwards to itself`,
+			},
+		},
+		{
+			name: "invalid variation",
+			bp: `
+				foo_module {
+					name: "libfoo_v2",
+				}
+
+				alias {
+					name: "libfoo",
+					actual: "libfoo_v2",
+					actual_variations: ["b"],
+				}
+			`,
+			errs: []string{
+				`Blueprints:9:23: module "libfoo": actual_variations: invalid variation "b", expected "mutator:variation"`,
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := NewContext()
+			ctx.RegisterModuleType("foo_module", newFooModule)
+			ctx.RegisterModuleType("alias", AliasModuleFactory)
+			ctx.RegisterBottomUpMutator("split", func(ctx BottomUpMutatorContext) {
+				if _, ok := ctx.Module().(*fooModule); ok {
+					ctx.CreateVariations("a", "b")
+				}
+			})
+			ctx.RegisterBottomUpMutator("deps", depsMutator)
+			ctx.MockFileSystem(map[string][]byte{
+				"Blueprints": []byte(tc.bp),
+			})
+
+			_, errs := ctx.ParseBlueprintsFiles("Blueprints", nil)
+			if len(errs) == 0 {
+				_, errs = ctx.ResolveDependencies(nil)
+			}
+			expectedErrors(t, errs, tc.errs...)
+			if len(errs) > 0 {
+				return
+			}
+
+			got := make(map[string][]string)
+			for _, module := range ctx.modulesSorted {
+				var deps []string
+				for _, dep := range module.directDeps {
+					deps = append(deps, dep.module.Name()+" "+dep.module.variantName)
+				}
+				if deps != nil {
+					got[module.Name()+" "+module.variantName] = deps
+				}
+			}
+			if !reflect.DeepEqual(got, tc.deps) {
+				t.Errorf("expected deps %q, got %q", tc.deps, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,124 @@
+// Copyright 2020 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blueprinttest
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/google/blueprint"
+)
+
+type testModule struct {
+	blueprint.SimpleName
+	properties struct {
+		Deps  []string
+		Split bool
+	}
+}
+
+func newTestModule() (blueprint.Module, []interface{}) {
+	m := &testModule{}
+	return m, []interface{}{&m.properties, &m.SimpleName.Properties}
+}
+
+func (m *testModule) GenerateBuildActions(blueprint.ModuleContext) {
+}
+
+type depTag struct {
+	blueprint.BaseDependencyTag
+}
+
+func splitMutator(ctx blueprint.BottomUpMutatorContext) {
+	if m, ok := ctx.Module().(*testModule); ok && m.properties.Split {
+		ctx.CreateVariations("a", "b")
+	}
+}
+
+func depsMutator(ctx blueprint.BottomUpMutatorContext) {
+	if m, ok := ctx.Module().(*testModule); ok {
+		ctx.AddDependency(ctx.Module(), depTag{}, m.properties.Deps...)
+	}
+}
+
+var prepareForTest = GroupFixtures(
+	RegisterModuleType("test_module", newTestModule),
+	RegisterBottomUpMutator("split", splitMutator),
+	RegisterBottomUpMutator("deps", depsMutator),
+)
+
+func TestRun(t *testing.T) {
+	result := Run(t,
+		prepareForTest,
+		WithBlueprints(`
+			test_module {
+				name: "A",
+				deps: ["B", "C"],
+				split: true,
+			}
+		`),
+		WithFiles(map[string]string{
+			"b/Blueprints": `test_module { name: "B", split: true }`,
+			"c/Blueprints": `test_module { name: "C" }`,
+		}),
+	)
+
+	if got := len(result.ModuleVariants("A")); got != 2 {
+		t.Errorf("expected 2 variants of A, got %d", got)
+	}
+
+	a := result.Module("A", blueprint.Variation{Mutator: "split", Variation: "b"})
+	if got := result.ModuleString(a); got != "A{b}" {
+		t.Errorf("expected A{b}, got %q", got)
+	}
+	if got, want := result.ModuleVariations(a),
+		[]blueprint.Variation{{Mutator: "split", Variation: "b"}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("expected variations %q, got %q", want, got)
+	}
+
+	result.AssertDirectDeps(a, "B{b}", "C")
+	result.AssertDependsOn(a, result.Module("C"))
+	result.AssertDirectDeps(result.Module("C"))
+}
+
+func TestRunOverrideFiles(t *testing.T) {
+	result := Run(t,
+		prepareForTest,
+		WithBlueprints(`test_module { name: "A", deps: ["B"] }`),
+		WithBlueprints(`test_module { name: "A" }`),
+	)
+
+	result.AssertDirectDeps(result.Module("A"))
+}
+
+func TestRunExpectErrors(t *testing.T) {
+	result := Run(t,
+		prepareForTest,
+		WithBlueprints(`
+			test_module {
+				name: "A",
+				deps: ["B", "C"],
+			}
+		`),
+		ExpectErrors(
+			`Blueprints:2:4: "A" depends on undefined module "C"`,
+			`Blueprints:2:4: "A" depends on undefined module "B"`,
+		),
+	)
+
+	if len(result.Errs) != 2 {
+		t.Errorf("expected 2 errors, got %q", result.Errs)
+	}
+}
@@ -0,0 +1,220 @@
+// Copyright 2020 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package blueprinttest provides a harness for testing module types, mutators and singletons.
+// A test is described by a set of Fixtures that register types on a blueprint.Context, add
+// Blueprints files to a mock filesystem and set the config, and is run with Run:
+//
+//	result := blueprinttest.Run(t,
+//		blueprinttest.RegisterModuleType("my_module", newMyModule),
+//		blueprinttest.RegisterBottomUpMutator("deps", depsMutator),
+//		blueprinttest.WithBlueprints(`
+//			my_module {
+//				name: "foo",
+//				deps: ["bar"],
+//			}
+//
+//			my_module {
+//				name: "bar",
+//			}
+//		`),
+//	)
+//	result.AssertDirectDeps(result.Module("foo"), "bar")
+//
+// Fixtures can be grouped with GroupFixtures to share common setup between tests.
+package blueprinttest
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/google/blueprint"
+)
+
+// A Fixture modifies the setup of a test run by Run.  Fixtures are applied in the order they are
+// passed to Run, so a later Fixture can override the files or config set by an earlier one.
+type Fixture func(s *setup)
+
+// setup holds the state built up by the Fixtures of a test.
+type setup struct {
+	registrations []func(ctx *blueprint.Context)
+	files         map[string][]byte
+	config        interface{}
+
+	expectedErrors []string
+	checkErrors    bool
+}
+
+// GroupFixtures returns a Fixture that applies each of the fixtures in order.
+func GroupFixtures(fixtures ...Fixture) Fixture {
+	return func(s *setup) {
+		for _, fixture := range fixtures {
+			fixture(s)
+		}
+	}
+}
+
+// ModifyContext returns a Fixture that calls modify on the Context before any Blueprints files are
+// parsed, for registrations or settings that do not have their own Fixture.
+func ModifyContext(modify func(ctx *blueprint.Context)) Fixture {
+	return func(s *setup) {
+		s.registrations = append(s.registrations, modify)
+	}
+}
+
+// RegisterModuleType returns a Fixture that registers a module type.
+func RegisterModuleType(name string, factory blueprint.ModuleFactory) Fixture {
+	return ModifyContext(func(ctx *blueprint.Context) {
+		ctx.RegisterModuleType(name, factory)
+	})
+}
+
+// RegisterBottomUpMutator returns a Fixture that registers a bottom up mutator.
+func RegisterBottomUpMutator(name string, mutator blueprint.BottomUpMutator) Fixture {
+	return ModifyContext(func(ctx *blueprint.Context) {
+		ctx.RegisterBottomUpMutator(name, mutator)
+	})
+}
+
+// RegisterTopDownMutator returns a Fixture that registers a top down mutator.
+func RegisterTopDownMutator(name string, mutator blueprint.TopDownMutator) Fixture {
+	return ModifyContext(func(ctx *blueprint.Context) {
+		ctx.RegisterTopDownMutator(name, mutator)
+	})
+}
+
+// RegisterSingletonType returns a Fixture that registers a singleton type.
+func RegisterSingletonType(name string, factory blueprint.SingletonFactory) Fixture {
+	return ModifyContext(func(ctx *blueprint.Context) {
+		ctx.RegisterSingletonType(name, factory)
+	})
+}
+
+// WithFile returns a Fixture that adds a file to the mock filesystem, replacing any file already
+// added at the same path.
+func WithFile(path string, contents string) Fixture {
+	return func(s *setup) {
+		if s.files == nil {
+			s.files = make(map[string][]byte)
+		}
+		s.files[path] = []byte(contents)
+	}
+}
+
+// WithFiles returns a Fixture that adds files to the mock filesystem.
+func WithFiles(files map[string]string) Fixture {
+	return func(s *setup) {
+		for path, contents := range files {
+			WithFile(path, contents)(s)
+		}
+	}
+}
+
+// WithBlueprints returns a Fixture that sets the contents of the top level Blueprints file.
+func WithBlueprints(contents string) Fixture {
+	return WithFile("Blueprints", contents)
+}
+
+// WithConfig returns a Fixture that sets the config passed to each phase of the Context.
+func WithConfig(config interface{}) Fixture {
+	return func(s *setup) {
+		s.config = config
+	}
+}
+
+// ExpectErrors returns a Fixture that makes Run expect the given error messages instead of
+// failing the test on any error.  The errors are compared without regard to their order, as the
+// order of errors reported by parallel mutators is not deterministic.  Run stops after the first
+// phase that reports errors.
+func ExpectErrors(messages ...string) Fixture {
+	return func(s *setup) {
+		s.expectedErrors = append(s.expectedErrors, messages...)
+		s.checkErrors = true
+	}
+}
+
+// Run creates a Context, applies the fixtures to it, and parses the Blueprints files in the mock
+// filesystem, resolves dependencies and prepares build actions.  Any errors fail the test unless
+// they were expected with ExpectErrors.
+func Run(t *testing.T, fixtures ...Fixture) *Result {
+	t.Helper()
+
+	s := &setup{}
+	GroupFixtures(fixtures...)(s)
+
+	ctx := blueprint.NewContext()
+	for _, register := range s.registrations {
+		register(ctx)
+	}
+
+	var blueprintsFiles []string
+	for path := range s.files {
+		if strings.HasSuffix(path, "/Blueprints") || path == "Blueprints" {
+			blueprintsFiles = append(blueprintsFiles, path)
+		}
+	}
+	if len(blueprintsFiles) == 0 {
+		t.Fatalf("no Blueprints files added to the mock filesystem")
+	}
+	sort.Strings(blueprintsFiles)
+
+	files := make(map[string][]byte, len(s.files)+1)
+	for path, contents := range s.files {
+		files[path] = contents
+	}
+	if _, exists := files[blueprint.MockModuleListFile]; !exists {
+		files[blueprint.MockModuleListFile] = []byte(strings.Join(blueprintsFiles, "\n"))
+	}
+	ctx.MockFileSystem(files)
+
+	result := &Result{
+		Context: ctx,
+		Config:  s.config,
+		t:       t,
+	}
+
+	phases := []func() ([]string, []error){
+		func() ([]string, []error) { return ctx.ParseBlueprintsFiles("Blueprints", s.config) },
+		func() ([]string, []error) { return ctx.ResolveDependencies(s.config) },
+		func() ([]string, []error) {
+			deps, _, errs := ctx.PrepareBuildActions(s.config)
+			return deps, errs
+		},
+	}
+	for _, phase := range phases {
+		_, errs := phase()
+		if len(errs) > 0 {
+			result.Errs = errs
+			break
+		}
+	}
+
+	if s.checkErrors {
+		result.AssertErrors(s.expectedErrors...)
+	} else if len(result.Errs) > 0 {
+		t.Fatalf("unexpected errors:\n%s", errorList(result.Errs))
+	}
+
+	return result
+}
+
+func errorList(errs []error) string {
+	var lines []string
+	for _, err := range errs {
+		lines = append(lines, fmt.Sprintf("    %s", err))
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,173 @@
+// Copyright 2020 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blueprinttest
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/google/blueprint"
+)
+
+// A Result is the Context of a test run by Run, along with the errors it reported.  Its methods
+// fail the test instead of returning errors.
+type Result struct {
+	*blueprint.Context
+
+	// Config is the config that was passed to each phase of the Context.
+	Config interface{}
+
+	// Errs contains the errors reported by the first phase that failed, if any.
+	Errs []error
+
+	t *testing.T
+}
+
+// ModuleVariants returns every variant of the module with the given name.
+func (r *Result) ModuleVariants(name string) []blueprint.Module {
+	var modules []blueprint.Module
+	r.VisitAllModules(func(module blueprint.Module) {
+		if r.ModuleName(module) == name {
+			modules = append(modules, module)
+		}
+	})
+	return modules
+}
+
+// Module returns the variant of the module with the given name that has all of the given
+// variations.  It fails the test if there is not exactly one such variant.
+func (r *Result) Module(name string, variations ...blueprint.Variation) blueprint.Module {
+	r.t.Helper()
+
+	variants := r.ModuleVariants(name)
+	if len(variants) == 0 {
+		r.t.Fatalf("module %q does not exist", name)
+	}
+
+	var matches []blueprint.Module
+	for _, variant := range variants {
+		if hasVariations(r.ModuleVariations(variant), variations) {
+			matches = append(matches, variant)
+		}
+	}
+
+	if len(matches) != 1 {
+		var available []string
+		for _, variant := range variants {
+			available = append(available, r.ModuleString(variant))
+		}
+		r.t.Fatalf("found %d variants of module %q with variations %s, available variants are:\n    %s",
+			len(matches), name, variationsString(variations), strings.Join(available, "\n    "))
+	}
+
+	return matches[0]
+}
+
+func hasVariations(have, want []blueprint.Variation) bool {
+	for _, w := range want {
+		found := false
+		for _, h := range have {
+			if h == w {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+func variationsString(variations []blueprint.Variation) string {
+	var parts []string
+	for _, v := range variations {
+		parts = append(parts, v.Mutator+":"+v.Variation)
+	}
+	return "[" + strings.Join(parts, ", ") + "]"
+}
+
+// ModuleString returns the name of a module followed by its variant in braces, for example
+// "libfoo{arm_shared}", or just the name if the module has not been split into variants.
+func (r *Result) ModuleString(module blueprint.Module) string {
+	name := r.ModuleName(module)
+	if variant := r.ModuleSubDir(module); variant != "" {
+		name += "{" + variant + "}"
+	}
+	return name
+}
+
+// DirectDeps returns the direct dependencies of a module formatted with ModuleString.
+func (r *Result) DirectDeps(module blueprint.Module) []string {
+	var deps []string
+	r.VisitDirectDeps(module, func(dep blueprint.Module) {
+		deps = append(deps, r.ModuleString(dep))
+	})
+	return deps
+}
+
+// AssertDirectDeps fails the test unless the direct dependencies of a module, formatted with
+// ModuleString, are exactly the expected ones in order.
+func (r *Result) AssertDirectDeps(module blueprint.Module, expected ...string) {
+	r.t.Helper()
+
+	deps := r.DirectDeps(module)
+	if len(deps) == 0 && len(expected) == 0 {
+		return
+	}
+	if !reflect.DeepEqual(deps, expected) {
+		r.t.Errorf("module %s: expected direct deps %q, got %q", r.ModuleString(module), expected, deps)
+	}
+}
+
+// AssertDependsOn fails the test unless the module has a direct dependency on dep.
+func (r *Result) AssertDependsOn(module blueprint.Module, dep blueprint.Module) {
+	r.t.Helper()
+
+	found := false
+	r.VisitDirectDeps(module, func(m blueprint.Module) {
+		if m == dep {
+			found = true
+		}
+	})
+	if !found {
+		r.t.Errorf("expected module %s to depend on %s, found deps %q",
+			r.ModuleString(module), r.ModuleString(dep), r.DirectDeps(module))
+	}
+}
+
+// AssertErrors fails the test unless the errors reported by the Context match the expected
+// messages, without regard to their order.
+func (r *Result) AssertErrors(expected ...string) {
+	r.t.Helper()
+
+	var got []string
+	for _, err := range r.Errs {
+		got = append(got, err.Error())
+	}
+	sort.Strings(got)
+	want := append([]string(nil), expected...)
+	sort.Strings(want)
+
+	if len(got) == 0 && len(want) == 0 {
+		return
+	}
+	if !reflect.DeepEqual(got, want) {
+		r.t.Errorf("expected errors:\n    %s\ngot:\n    %s",
+			strings.Join(want, "\n    "), strings.Join(got, "\n    "))
+	}
+}
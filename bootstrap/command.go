@@ -15,38 +15,57 @@
 package bootstrap
 
 import (
-	"bufio"
+	"bytes"
 	"flag"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"os"
+	"os/signal"
 	"path/filepath"
 	"runtime"
 	"runtime/debug"
 	"runtime/pprof"
 	"runtime/trace"
+	"strings"
+	"sync"
+	"syscall"
 
 	"github.com/google/blueprint"
 	"github.com/google/blueprint/deptools"
+	"github.com/google/blueprint/pathtools"
 )
 
 var (
-	outFile        string
-	globFile       string
-	depFile        string
-	docFile        string
-	cpuprofile     string
-	memprofile     string
-	traceFile      string
-	runGoTests     bool
-	noGC           bool
-	emptyNinjaFile bool
-	BuildDir       string
-	ModuleListFile string
-	NinjaBuildDir  string
-	SrcDir         string
-	absSrcDir      string
+	outFile          string
+	globFile         string
+	depFile          string
+	docFile          string
+	outputOwners     string
+	ninjaShardDir    string
+	ninjaShards      int
+	regenSummaryFile string
+	regenStateFile   string
+	noLineWrapping   bool
+	hoistBuildArgs   int
+	buildLocations   bool
+	cpuprofile       string
+	memprofile       string
+	traceFile        string
+	profileFile      string
+	runGoTests       bool
+	noGC             bool
+	emptyNinjaFile   bool
+	werror           string
+	wsuppress        string
+	verifyOutput     bool
+	printMutators    bool
+	checkMutators    bool
+	BuildDir         string
+	ModuleListFile   string
+	NinjaBuildDir    string
+	SrcDir           string
+	absSrcDir        string
 )
 
 func init() {
@@ -56,13 +75,27 @@ func init() {
 	flag.StringVar(&NinjaBuildDir, "n", "", "the ninja builddir directory")
 	flag.StringVar(&depFile, "d", "", "the dependency file to output")
 	flag.StringVar(&docFile, "docs", "", "build documentation file to output")
+	flag.StringVar(&outputOwners, "output-owners", "", "write a JSON map from every output to the module or singleton that builds it to file")
+	flag.StringVar(&ninjaShardDir, "ninja-shard-dir", "", "write the build actions of modules to Ninja files in dir that are included from the Ninja file, removing any other files in dir")
+	flag.IntVar(&ninjaShards, "ninja-shards", 0, "the number of Ninja files to divide modules between with -ninja-shard-dir, or 0 for one per Blueprints directory")
+	flag.StringVar(&regenSummaryFile, "regen-summary", "", "write a summary of the files that were updated while regenerating the Ninja file to file")
+	flag.StringVar(&regenStateFile, "regen-state", "", "record the inputs of the Ninja file with content hashes in file and print which of them changed since the previous run")
+	flag.BoolVar(&noLineWrapping, "no-ninja-line-wrapping", false, "don't wrap long lines in the Ninja file, which makes writing it faster")
+	flag.IntVar(&hoistBuildArgs, "hoist-build-args", 0, "move rule arguments shared by at least this many build statements into global variables, or 0 to disable")
+	flag.BoolVar(&buildLocations, "build-location-comments", false, "precede each build statement in the Ninja file with the module and Go code that created it")
 	flag.StringVar(&cpuprofile, "cpuprofile", "", "write cpu profile to file")
 	flag.StringVar(&traceFile, "trace", "", "write trace to file")
 	flag.StringVar(&memprofile, "memprofile", "", "write memory profile to file")
+	flag.StringVar(&profileFile, "profile", "", "write a Chrome trace of the time spent in each mutator, module and singleton to file")
 	flag.BoolVar(&noGC, "nogc", false, "turn off GC for debugging")
 	flag.BoolVar(&runGoTests, "t", false, "build and run go tests during bootstrap")
 	flag.StringVar(&ModuleListFile, "l", "", "file that lists filepaths to parse")
 	flag.BoolVar(&emptyNinjaFile, "empty-ninja-file", false, "write out a 0-byte ninja file")
+	flag.StringVar(&werror, "warnings-as-errors", "", "comma-separated list of warning categories to treat as errors, or \"all\"")
+	flag.StringVar(&wsuppress, "suppress-warnings", "", "comma-separated list of warning categories to suppress, or \"all\"")
+	flag.BoolVar(&printMutators, "print-mutator-order", false, "print the order the mutators will run in")
+	flag.BoolVar(&checkMutators, "check-parallel-mutators", false, "report parallel mutators that modify the properties of modules other than the one they are visiting")
+	flag.BoolVar(&verifyOutput, "verify-deterministic", false, "generate the Ninja file a second time in a different module order and fail if it differs")
 }
 
 func Main(ctx *blueprint.Context, config interface{}, extraNinjaFileDeps ...string) {
@@ -72,20 +105,34 @@ func Main(ctx *blueprint.Context, config interface{}, extraNinjaFileDeps ...stri
 
 	runtime.GOMAXPROCS(runtime.NumCPU())
 
+	if checkMutators {
+		ctx.SetCheckParallelMutators(true)
+	}
+
+	if regenStateFile != "" {
+		ctx.SetHashBlueprintsFiles(true)
+	}
+
 	if noGC {
 		debug.SetGCPercent(-1)
 	}
 
 	absSrcDir = ctx.SrcDir()
 
+	// Profiles and traces are written by atExit functions, so that they are also written when the
+	// run fails or is interrupted, which are often the runs that need to be profiled.
+	defer runAtExit()
+
 	if cpuprofile != "" {
 		f, err := os.Create(absolutePath(cpuprofile))
 		if err != nil {
 			fatalf("error opening cpuprofile: %s", err)
 		}
 		pprof.StartCPUProfile(f)
-		defer f.Close()
-		defer pprof.StopCPUProfile()
+		atExit(func() {
+			pprof.StopCPUProfile()
+			f.Close()
+		})
 	}
 
 	if traceFile != "" {
@@ -94,8 +141,35 @@ func Main(ctx *blueprint.Context, config interface{}, extraNinjaFileDeps ...stri
 			fatalf("error opening trace: %s", err)
 		}
 		trace.Start(f)
-		defer f.Close()
-		defer trace.Stop()
+		atExit(func() {
+			trace.Stop()
+			f.Close()
+		})
+	}
+
+	if profileFile != "" {
+		ctx.SetProfiling(true)
+		atExit(func() {
+			f, err := os.Create(absolutePath(profileFile))
+			if err != nil {
+				fmt.Printf("error opening profile: %s\n", err)
+				return
+			}
+			defer f.Close()
+			if err := ctx.WriteProfile(f); err != nil {
+				fmt.Printf("error writing profile: %s\n", err)
+			}
+		})
+	}
+
+	if cpuprofile != "" || traceFile != "" || profileFile != "" {
+		sigCh := make(chan os.Signal, 1)
+		signal.Notify(sigCh, os.Interrupt, syscall.SIGTERM)
+		atExit(func() { signal.Stop(sigCh) })
+		go func() {
+			sig := <-sigCh
+			fatalf("%s, writing profiles before exiting", sig)
+		}()
 	}
 
 	if flag.NArg() != 1 {
@@ -134,13 +208,7 @@ func Main(ctx *blueprint.Context, config interface{}, extraNinjaFileDeps ...stri
 		moduleListFile:         ModuleListFile,
 	}
 
-	ctx.RegisterBottomUpMutator("bootstrap_plugin_deps", pluginDeps)
-	ctx.RegisterModuleType("bootstrap_go_package", newGoPackageModuleFactory(bootstrapConfig))
-	ctx.RegisterModuleType("bootstrap_go_binary", newGoBinaryModuleFactory(bootstrapConfig, false))
-	ctx.RegisterModuleType("blueprint_go_binary", newGoBinaryModuleFactory(bootstrapConfig, true))
-	ctx.RegisterSingletonType("bootstrap", newSingletonFactory(bootstrapConfig))
-
-	ctx.RegisterSingletonType("glob", globSingletonFactory(ctx))
+	registerBootstrapTypes(ctx, bootstrapConfig)
 
 	deps, errs := ctx.ParseFileList(filepath.Dir(bootstrapConfig.topLevelBlueprintsFile), filesToParse, config)
 	if len(errs) > 0 {
@@ -150,6 +218,13 @@ func Main(ctx *blueprint.Context, config interface{}, extraNinjaFileDeps ...stri
 	// Add extra ninja file dependencies
 	deps = append(deps, extraNinjaFileDeps...)
 
+	if printMutators {
+		fmt.Println("mutator order:")
+		if errs := ctx.WriteMutatorOrder(os.Stdout); len(errs) > 0 {
+			fatalErrors(errs)
+		}
+	}
+
 	extraDeps, errs := ctx.ResolveDependencies(config)
 	if len(errs) > 0 {
 		fatalErrors(errs)
@@ -157,6 +232,7 @@ func Main(ctx *blueprint.Context, config interface{}, extraNinjaFileDeps ...stri
 	deps = append(deps, extraDeps...)
 
 	if docFile != "" {
+		reportWarnings(ctx.Warnings())
 		err := writeDocs(ctx, absolutePath(docFile))
 		if err != nil {
 			fatalErrors([]error{err})
@@ -166,38 +242,60 @@ func Main(ctx *blueprint.Context, config interface{}, extraNinjaFileDeps ...stri
 
 	if c, ok := config.(ConfigStopBefore); ok {
 		if c.StopBefore() == StopBeforePrepareBuildActions {
+			reportWarnings(ctx.Warnings())
 			return
 		}
 	}
 
-	extraDeps, errs = ctx.PrepareBuildActions(config)
+	ctx.SetHoistBuildArgs(hoistBuildArgs)
+	ctx.SetBuildLocationComments(buildLocations)
+
+	extraDeps, warnings, errs := ctx.PrepareBuildActions(config)
 	if len(errs) > 0 {
 		fatalErrors(errs)
 	}
 	deps = append(deps, extraDeps...)
 
-	const outFilePermissions = 0666
-	var out io.Writer
-	var f *os.File
-	var buf *bufio.Writer
+	reportWarnings(warnings)
 
-	if emptyNinjaFile {
-		if err := ioutil.WriteFile(absolutePath(outFile), []byte(nil), outFilePermissions); err != nil {
-			fatalf("error writing empty Ninja file: %s", err)
+	if outputOwners != "" {
+		err := writeOutputOwners(ctx, absolutePath(outputOwners))
+		if err != nil {
+			fatalf("error writing output owners: %s", err)
 		}
 	}
 
-	if stage != StageMain || !emptyNinjaFile {
-		f, err = os.OpenFile(absolutePath(outFile), os.O_WRONLY|os.O_CREATE|os.O_TRUNC, outFilePermissions)
+	summary := &regenSummary{}
+
+	var state *regenState
+	if regenStateFile != "" {
+		state, err = newRegenState(config, ctx.BlueprintsFileHashes(), deps, ctx.Globs())
 		if err != nil {
-			fatalf("error opening Ninja file: %s", err)
+			fatalf("error hashing the inputs of the Ninja file: %s", err)
+		}
+		prevState, err := readRegenState(absolutePath(regenStateFile))
+		if err != nil {
+			fatalf("error reading regeneration state: %s", err)
+		}
+		// Only changed inputs are worth printing on every run, the other explanations are just
+		// recorded in the summary.
+		reasons := state.explain(prevState)
+		switch {
+		case prevState == nil:
+			summary.recordf("regenerated because: no record of the inputs of a previous run")
+		case len(reasons) == 0:
+			summary.recordf("regenerated because: none of the recorded inputs changed")
+		default:
+			fmt.Printf("%s regenerated because:\n", outFile)
+			for _, reason := range reasons {
+				fmt.Printf("  %s\n", reason)
+				summary.recordf("regenerated because: %s", reason)
+			}
 		}
-		buf = bufio.NewWriter(f)
-		out = buf
-	} else {
-		out = ioutil.Discard
 	}
 
+	const outFilePermissions = 0666
+
 	if globFile != "" {
 		buffer, errs := generateGlobNinjaFile(ctx.Globs)
 		if len(errs) > 0 {
@@ -217,16 +315,61 @@ func Main(ctx *blueprint.Context, config interface{}, extraNinjaFileDeps ...stri
 		}
 	}
 
+	ctx.SetNinjaLineWrapping(!noLineWrapping)
+
+	if ninjaShardDir != "" {
+		ctx.SetNinjaShards(&blueprint.NinjaShards{
+			Dir:      absolutePath(ninjaShardDir),
+			NinjaDir: ninjaShardDir,
+			Count:    ninjaShards,
+		})
+	}
+
+	var out io.Writer
+	var f *pathtools.FileIfChangedWriter
+
+	if stage != StageMain || !emptyNinjaFile {
+		// The Ninja file is streamed to a temporary file that only replaces it if the contents
+		// changed, so that Ninja doesn't see a new timestamp when nothing changed.  When
+		// -ninja-shard-dir is set it still changes whenever a shard does, because it contains a hash
+		// of each shard.  It isn't buffered here because WriteBuildFile already buffers its output.
+		f, err = pathtools.CreateFileIfChanged(absolutePath(outFile), outFilePermissions)
+		if err != nil {
+			fatalf("error opening Ninja file: %s", err)
+		}
+		// Abort does nothing once the file has been closed, so this only discards the Ninja file
+		// when Main exits with an error before it is complete and verified.
+		atExit(f.Abort)
+		out = f
+	} else {
+		f, err = pathtools.CreateFileIfChanged(absolutePath(outFile), outFilePermissions)
+		if err == nil {
+			err = f.Close()
+		}
+		if err != nil {
+			fatalf("error writing empty Ninja file: %s", err)
+		}
+		summary.recordFile(outFile, f.Changed())
+		f = nil
+		out = ioutil.Discard
+	}
+
+	var verifyBuf *bytes.Buffer
+	if verifyOutput {
+		verifyBuf = &bytes.Buffer{}
+		out = io.MultiWriter(out, verifyBuf)
+	}
+
 	err = ctx.WriteBuildFile(out)
 	if err != nil {
 		fatalf("error writing Ninja file contents: %s", err)
 	}
 
-	if buf != nil {
-		err = buf.Flush()
-		if err != nil {
-			fatalf("error flushing Ninja file contents: %s", err)
-		}
+	// Verify the output before the Ninja file replaces the old one, so that a nondeterministic
+	// Ninja file is never newer than its inputs and the failure is reported again by the next
+	// build.
+	if verifyBuf != nil {
+		verifyDeterministic(config, bootstrapConfig, filesToParse, verifyBuf.Bytes())
 	}
 
 	if f != nil {
@@ -234,6 +377,21 @@ func Main(ctx *blueprint.Context, config interface{}, extraNinjaFileDeps ...stri
 		if err != nil {
 			fatalf("error closing Ninja file: %s", err)
 		}
+		summary.recordFile(outFile, f.Changed())
+	}
+
+	if state != nil {
+		err = state.write(absolutePath(regenStateFile))
+		if err != nil {
+			fatalf("error writing regeneration state: %s", err)
+		}
+	}
+
+	if regenSummaryFile != "" {
+		err = summary.write(absolutePath(regenSummaryFile))
+		if err != nil {
+			fatalf("error writing regeneration summary: %s", err)
+		}
 	}
 
 	if c, ok := config.(ConfigRemoveAbandonedFilesUnder); ok {
@@ -254,9 +412,60 @@ func Main(ctx *blueprint.Context, config interface{}, extraNinjaFileDeps ...stri
 	}
 }
 
+func registerBootstrapTypes(ctx *blueprint.Context, bootstrapConfig *Config) {
+	ctx.RegisterBottomUpMutator("bootstrap_plugin_deps", pluginDeps)
+	ctx.RegisterModuleType("bootstrap_go_package", newGoPackageModuleFactory(bootstrapConfig))
+	ctx.RegisterModuleType("bootstrap_go_binary", newGoBinaryModuleFactory(bootstrapConfig, false))
+	ctx.RegisterModuleType("blueprint_go_binary", newGoBinaryModuleFactory(bootstrapConfig, true))
+	ctx.RegisterSingletonType("bootstrap", newSingletonFactory(bootstrapConfig))
+
+	ctx.RegisterSingletonType("glob", globSingletonFactory(ctx))
+}
+
+func writeOutputOwners(ctx *blueprint.Context, filename string) error {
+	f, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	err = ctx.WriteOutputOwners(f)
+	if err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+var (
+	atExitLock  sync.Mutex
+	atExitFuncs []func()
+)
+
+// atExit registers f to be called when Main returns, or when it exits through fatalf or
+// fatalErrors.  The functions are called in the reverse order that they were registered.
+func atExit(f func()) {
+	atExitLock.Lock()
+	defer atExitLock.Unlock()
+	atExitFuncs = append(atExitFuncs, f)
+}
+
+// runAtExit calls the functions registered with atExit.  Each function is only called once, even
+// if runAtExit is called again while they are running.
+func runAtExit() {
+	atExitLock.Lock()
+	funcs := atExitFuncs
+	atExitFuncs = nil
+	atExitLock.Unlock()
+
+	for i := len(funcs) - 1; i >= 0; i-- {
+		funcs[i]()
+	}
+}
+
 func fatalf(format string, args ...interface{}) {
 	fmt.Printf(format, args...)
 	fmt.Print("\n")
+	runAtExit()
 	os.Exit(1)
 }
 
@@ -274,9 +483,45 @@ func fatalErrors(errs []error) {
 			fmt.Printf("%sinternal error:%s %s\n", red, unred, err)
 		}
 	}
+	runAtExit()
 	os.Exit(1)
 }
 
+// reportWarnings prints each warning that is not suppressed by -suppress-warnings, and exits after
+// printing any warnings that are promoted to errors by -warnings-as-errors.
+func reportWarnings(warnings []*blueprint.Warning) {
+	magenta := "\x1b[35m"
+	unmagenta := "\x1b[0m"
+
+	var errs []error
+	for _, w := range warnings {
+		if warningCategoryInList(w.Category, wsuppress) {
+			continue
+		}
+		if warningCategoryInList(w.Category, werror) {
+			errs = append(errs, w.Err)
+			continue
+		}
+		fmt.Printf("%swarning:%s %s\n", magenta, unmagenta, w)
+	}
+
+	if len(errs) > 0 {
+		fatalErrors(errs)
+	}
+}
+
+func warningCategoryInList(category, list string) bool {
+	if list == "" {
+		return false
+	}
+	for _, c := range strings.Split(list, ",") {
+		if c == "all" || c == category {
+			return true
+		}
+	}
+	return false
+}
+
 func absolutePath(path string) string {
 	if filepath.IsAbs(path) {
 		return path
@@ -93,6 +93,21 @@ type ConfigStopBefore interface {
 	StopBefore() StopBefore
 }
 
+type ConfigVerifyDeterministic interface {
+	// NewVerificationContext should return a new Context set up the same way as the one passed to
+	// Main, with the same source directory, module types, mutators and singletons registered.  It
+	// is used by -verify-deterministic to generate the Ninja file a second time.
+	NewVerificationContext() *blueprint.Context
+}
+
+type ConfigEnvironmentInputs interface {
+	// EnvironmentInputs should return the environment variables that the config read, mapped to
+	// the values it saw.  They are recorded by -regen-state so that a change to one of them can be
+	// reported as the reason the Ninja file was regenerated.  Blueprint and minibp don't read the
+	// environment, so only primary builders that do need to implement it.
+	EnvironmentInputs() map[string]string
+}
+
 type Stage int
 
 const (
@@ -165,7 +165,7 @@ func generateGlobNinjaFile(globLister func() []blueprint.GlobPath) ([]byte, []er
 		return nil, errs
 	}
 
-	extraDeps, errs = ctx.PrepareBuildActions(nil)
+	extraDeps, _, errs = ctx.PrepareBuildActions(nil)
 	if len(extraDeps) > 0 {
 		return nil, []error{fmt.Errorf("shouldn't have extra deps")}
 	}
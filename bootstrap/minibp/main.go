@@ -45,13 +45,22 @@ func (c Config) RemoveAbandonedFilesUnder() (under, exempt []string) {
 	return
 }
 
-func main() {
-	flag.Parse()
+func (c Config) NewVerificationContext() *blueprint.Context {
+	return newContext()
+}
 
+func newContext() *blueprint.Context {
 	ctx := blueprint.NewContext()
 	if !runAsPrimaryBuilder {
 		ctx.SetIgnoreUnknownModuleTypes(true)
 	}
+	return ctx
+}
+
+func main() {
+	flag.Parse()
+
+	ctx := newContext()
 
 	config := Config{
 		generatingPrimaryBuilder: !runAsPrimaryBuilder,
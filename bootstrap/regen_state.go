@@ -0,0 +1,207 @@
+// Copyright 2020 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bootstrap
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/google/blueprint"
+)
+
+// A regenState records the inputs that the Ninja file was generated from, with a hash of the
+// contents of each, so that the next run can explain why it was regenerated.  It is written to the
+// file passed to -regen-state.
+type regenState struct {
+	// BlueprintsFiles maps each Blueprints file that was parsed to the hash of its contents.
+	BlueprintsFiles map[string]string
+	// Files maps every other file the Ninja file depends on to the hash of its contents.
+	Files map[string]string
+	// Globs maps each glob pattern to the hash of the list of files it matched.
+	Globs map[string]string
+	// Environment maps each environment variable returned by ConfigEnvironmentInputs to the hash
+	// of its value.  Values are hashed so that the state file doesn't contain them.
+	Environment map[string]string
+}
+
+const missingFileHash = "missing"
+
+// newRegenState hashes the inputs of the Ninja file.  blueprintsHashes are the hashes of the
+// Blueprints files recorded while they were parsed, so that they match the contents the Ninja file
+// was generated from, and deps are the dependencies of the Ninja file.
+func newRegenState(config interface{}, blueprintsHashes map[string]string, deps []string,
+	globs []blueprint.GlobPath) (*regenState, error) {
+
+	s := &regenState{
+		BlueprintsFiles: make(map[string]string),
+		Files:           make(map[string]string),
+		Globs:           make(map[string]string),
+		Environment:     make(map[string]string),
+	}
+
+	for file, hash := range blueprintsHashes {
+		s.BlueprintsFiles[file] = hash
+	}
+
+	for _, file := range deps {
+		if _, ok := s.BlueprintsFiles[file]; ok {
+			continue
+		}
+		hash, err := hashFile(file)
+		if err != nil {
+			return nil, err
+		}
+		s.Files[file] = hash
+	}
+
+	for _, g := range globs {
+		s.Globs[globDescription(g)] = hashString(strings.Join(g.Files, "\n"))
+	}
+
+	if c, ok := config.(ConfigEnvironmentInputs); ok {
+		for name, value := range c.EnvironmentInputs() {
+			s.Environment[name] = hashString(value)
+		}
+	}
+
+	return s, nil
+}
+
+// hashFile returns the hash of the contents of a file, or of the sorted list of names in a
+// directory, which Ninja also accepts as a dependency.
+func hashFile(file string) (string, error) {
+	f, err := os.Open(absolutePath(file))
+	if os.IsNotExist(err) {
+		return missingFileHash, nil
+	} else if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return "", err
+	}
+	if info.IsDir() {
+		names, err := f.Readdirnames(-1)
+		if err != nil {
+			return "", fmt.Errorf("error listing %s: %s", file, err)
+		}
+		sort.Strings(names)
+		return hashString(strings.Join(names, "\n")), nil
+	}
+
+	h := sha1.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", fmt.Errorf("error hashing %s: %s", file, err)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func hashString(s string) string {
+	h := sha1.Sum([]byte(s))
+	return hex.EncodeToString(h[:])
+}
+
+func globDescription(g blueprint.GlobPath) string {
+	if len(g.Excludes) == 0 {
+		return g.Pattern
+	}
+	return g.Pattern + " excluding " + strings.Join(g.Excludes, ", ")
+}
+
+// readRegenState reads the state written by a previous run.  It returns nil without an error if
+// the file does not exist.
+func readRegenState(filename string) (*regenState, error) {
+	data, err := ioutil.ReadFile(filename)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	s := &regenState{}
+	if err := json.Unmarshal(data, s); err != nil {
+		return nil, fmt.Errorf("error parsing %s: %s", filename, err)
+	}
+	return s, nil
+}
+
+func (s *regenState) write(filename string) error {
+	// encoding/json sorts map keys, so the file is deterministic.
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filename, append(data, '\n'), 0666)
+}
+
+// explain returns a line for each input that differs between prev and s.  prev may be nil if
+// there was no previous run, in which case there is nothing to compare and it returns nil.
+func (s *regenState) explain(prev *regenState) []string {
+	if prev == nil {
+		return nil
+	}
+
+	var reasons []string
+	reasons = append(reasons, diffRegenInputs("Blueprints file", prev.BlueprintsFiles, s.BlueprintsFiles)...)
+	reasons = append(reasons, diffRegenInputs("glob", prev.Globs, s.Globs)...)
+	reasons = append(reasons, diffRegenInputs("environment variable", prev.Environment, s.Environment)...)
+	reasons = append(reasons, diffRegenInputs("file", prev.Files, s.Files)...)
+	return reasons
+}
+
+func diffRegenInputs(kind string, prev, cur map[string]string) []string {
+	var reasons []string
+
+	for _, name := range sortedKeys(cur) {
+		prevHash, ok := prev[name]
+		switch {
+		case !ok:
+			reasons = append(reasons, fmt.Sprintf("%s %s was added", kind, name))
+		case prevHash == cur[name]:
+		case cur[name] == missingFileHash:
+			reasons = append(reasons, fmt.Sprintf("%s %s was deleted", kind, name))
+		case kind == "glob":
+			reasons = append(reasons, fmt.Sprintf("%s %s matches different files", kind, name))
+		default:
+			reasons = append(reasons, fmt.Sprintf("%s %s changed", kind, name))
+		}
+	}
+
+	for _, name := range sortedKeys(prev) {
+		if _, ok := cur[name]; !ok {
+			reasons = append(reasons, fmt.Sprintf("%s %s is no longer an input", kind, name))
+		}
+	}
+
+	return reasons
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,222 @@
+// Copyright 2020 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bootstrap
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/google/blueprint"
+)
+
+func TestRegenStateExplain(t *testing.T) {
+	prev := &regenState{
+		BlueprintsFiles: map[string]string{
+			"Blueprints":       "1",
+			"a/Blueprints":     "2",
+			"b/Blueprints":     "3",
+			"gone/Blueprints":  "4",
+			"moved/Blueprints": "5",
+		},
+		Files: map[string]string{
+			"out/.bp.list": "6",
+		},
+		Globs: map[string]string{
+			"a/*.c":                 "7",
+			"b/*.c excluding b/x.c": "8",
+			"unused/*":              "9",
+		},
+		Environment: map[string]string{
+			"TARGET": "10",
+		},
+	}
+
+	testCases := []struct {
+		name string
+		prev *regenState
+		cur  *regenState
+		want []string
+	}{
+		{
+			name: "no previous state",
+			prev: nil,
+			cur:  prev,
+			want: nil,
+		},
+		{
+			name: "unchanged",
+			prev: prev,
+			cur:  prev,
+			want: nil,
+		},
+		{
+			name: "changed",
+			prev: prev,
+			cur: &regenState{
+				BlueprintsFiles: map[string]string{
+					"Blueprints":      "1",
+					"a/Blueprints":    "2a",
+					"b/Blueprints":    "3",
+					"c/Blueprints":    "11",
+					"gone/Blueprints": missingFileHash,
+				},
+				Files: map[string]string{
+					"out/.bp.list": "6a",
+				},
+				Globs: map[string]string{
+					"a/*.c":                 "7a",
+					"b/*.c excluding b/x.c": "8",
+				},
+				Environment: map[string]string{
+					"TARGET":  "10a",
+					"VARIANT": "12",
+				},
+			},
+			want: []string{
+				"Blueprints file a/Blueprints changed",
+				"Blueprints file c/Blueprints was added",
+				"Blueprints file gone/Blueprints was deleted",
+				"Blueprints file moved/Blueprints is no longer an input",
+				"glob a/*.c matches different files",
+				"glob unused/* is no longer an input",
+				"environment variable TARGET changed",
+				"environment variable VARIANT was added",
+				"file out/.bp.list changed",
+			},
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			got := testCase.cur.explain(testCase.prev)
+			if !reflect.DeepEqual(got, testCase.want) {
+				t.Errorf("incorrect explanation:\nwant:\n  %q\ngot:\n  %q", testCase.want, got)
+			}
+		})
+	}
+}
+
+func TestRegenStateRoundTrip(t *testing.T) {
+	dir, err := ioutil.TempDir("", "regen_state")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	blueprints := filepath.Join(dir, "Blueprints")
+	if err := ioutil.WriteFile(blueprints, []byte("bootstrap_go_package {}\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	// Ninja accepts directories as dependencies, so they are hashed by their listing.
+	subdir := filepath.Join(dir, "subdir")
+	if err := os.Mkdir(subdir, 0777); err != nil {
+		t.Fatal(err)
+	}
+	missing := filepath.Join(dir, "missing")
+
+	globs := []blueprint.GlobPath{
+		{Pattern: "*.go", Excludes: []string{"*_test.go"}, Files: []string{"a.go", "b.go"}},
+	}
+
+	// The hashes of Blueprints files are recorded while they are parsed, not by hashing them again.
+	blueprintsHashes := map[string]string{blueprints: "parsed"}
+
+	state, err := newRegenState(nil, blueprintsHashes, []string{blueprints, subdir, missing}, globs)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if _, ok := state.Files[blueprints]; ok {
+		t.Errorf("expected Blueprints file to only be recorded in BlueprintsFiles")
+	}
+	if state.BlueprintsFiles[blueprints] != "parsed" {
+		t.Errorf("expected Blueprints file to be recorded with the hash from parsing, got %q",
+			state.BlueprintsFiles[blueprints])
+	}
+	if state.Files[missing] != missingFileHash {
+		t.Errorf("expected missing file to be recorded as %q, got %q", missingFileHash, state.Files[missing])
+	}
+	if _, ok := state.Globs["*.go excluding *_test.go"]; !ok {
+		t.Errorf("expected glob to be recorded with its excludes, got %q", state.Globs)
+	}
+
+	stateFile := filepath.Join(dir, "state.json")
+
+	if prev, err := readRegenState(stateFile); err != nil || prev != nil {
+		t.Errorf("expected no state before it is written, got %v, %v", prev, err)
+	}
+
+	if err := state.write(stateFile); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	got, err := readRegenState(stateFile)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !reflect.DeepEqual(got, state) {
+		t.Errorf("incorrect state read back:\nwant:\n  %#v\ngot:\n  %#v", state, got)
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(subdir, "new"), nil, 0666); err != nil {
+		t.Fatal(err)
+	}
+	next, err := newRegenState(nil, blueprintsHashes, []string{subdir, missing}, globs)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	want := []string{"file " + subdir + " changed"}
+	if reasons := next.explain(got); !reflect.DeepEqual(reasons, want) {
+		t.Errorf("incorrect explanation:\nwant:\n  %q\ngot:\n  %q", want, reasons)
+	}
+}
+
+type regenStateTestConfig map[string]string
+
+func (c regenStateTestConfig) EnvironmentInputs() map[string]string {
+	return c
+}
+
+func TestRegenStateEnvironment(t *testing.T) {
+	hash := func(env map[string]string) map[string]string {
+		t.Helper()
+		state, err := newRegenState(regenStateTestConfig(env), nil, nil, nil)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		return state.Environment
+	}
+
+	env := hash(map[string]string{"TARGET": "secret value", "EMPTY": ""})
+	if len(env) != 2 {
+		t.Fatalf("expected 2 environment inputs, got %q", env)
+	}
+	if env["TARGET"] == "secret value" {
+		t.Errorf("expected environment values to be hashed")
+	}
+
+	prev := &regenState{Environment: env}
+	cur := &regenState{Environment: hash(map[string]string{"TARGET": "other value"})}
+	want := []string{
+		"environment variable TARGET changed",
+		"environment variable EMPTY is no longer an input",
+	}
+	if got := cur.explain(prev); !reflect.DeepEqual(got, want) {
+		t.Errorf("incorrect explanation:\nwant:\n  %q\ngot:\n  %q", want, got)
+	}
+}
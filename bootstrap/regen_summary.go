@@ -0,0 +1,49 @@
+// Copyright 2020 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bootstrap
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+)
+
+// A regenSummary records the decisions made while regenerating the Ninja file so that they can be
+// written to the file passed to -regen-summary.
+type regenSummary struct {
+	lines []string
+}
+
+func (s *regenSummary) recordf(format string, args ...interface{}) {
+	s.lines = append(s.lines, fmt.Sprintf(format, args...))
+}
+
+// recordFile records whether a generated file was replaced or left untouched because its contents
+// were unchanged.
+func (s *regenSummary) recordFile(filename string, changed bool) {
+	if changed {
+		s.recordf("%s: contents changed, replaced", filename)
+	} else {
+		s.recordf("%s: contents unchanged, not replaced", filename)
+	}
+}
+
+func (s *regenSummary) write(filename string) error {
+	buf := &bytes.Buffer{}
+	for _, line := range s.lines {
+		fmt.Fprintln(buf, line)
+	}
+	return ioutil.WriteFile(filename, buf.Bytes(), 0666)
+}
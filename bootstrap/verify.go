@@ -0,0 +1,256 @@
+// Copyright 2020 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bootstrap
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/blueprint"
+)
+
+const (
+	// The number of unchanged lines printed around a difference in the Ninja output.
+	verifyDiffContext = 3
+	// The maximum number of lines printed from each run.
+	verifyDiffMaxLines = 100
+)
+
+// verifyDeterministic generates the Ninja file a second time using a new Context from config,
+// visiting modules one at a time in a shuffled order, and exits with a diff if the output differs
+// from expected.  With -ninja-shard-dir the shards of the second run are written to a scratch
+// directory and compared with those in the shard directory too.
+func verifyDeterministic(config interface{}, bootstrapConfig *Config, filesToParse []string,
+	expected []byte) {
+
+	c, ok := config.(ConfigVerifyDeterministic)
+	if !ok {
+		fatalf("-verify-deterministic requires a config that implements ConfigVerifyDeterministic")
+	}
+
+	ctx := c.NewVerificationContext()
+	seed := time.Now().UnixNano()
+	ctx.SetParallelism(1)
+	ctx.SetShuffleVisitOrder(seed)
+	ctx.SetNinjaLineWrapping(!noLineWrapping)
+	ctx.SetHoistBuildArgs(hoistBuildArgs)
+	ctx.SetBuildLocationComments(buildLocations)
+
+	var scratchDir string
+	if ninjaShardDir != "" {
+		var err error
+		scratchDir, err = ioutil.TempDir("", "verify_shards")
+		if err != nil {
+			fatalf("error creating shard directory for -verify-deterministic run: %s", err)
+		}
+		atExit(func() { os.RemoveAll(scratchDir) })
+
+		// The shards are referenced by the same paths as in the first run, so that the main Ninja
+		// files only differ if the shards do.
+		ctx.SetNinjaShards(&blueprint.NinjaShards{
+			Dir:      scratchDir,
+			NinjaDir: ninjaShardDir,
+			Count:    ninjaShards,
+		})
+	}
+
+	registerBootstrapTypes(ctx, bootstrapConfig)
+
+	_, errs := ctx.ParseFileList(filepath.Dir(bootstrapConfig.topLevelBlueprintsFile), filesToParse, config)
+	if len(errs) == 0 {
+		_, errs = ctx.ResolveDependencies(config)
+	}
+	if len(errs) == 0 {
+		_, _, errs = ctx.PrepareBuildActions(config)
+	}
+	if len(errs) > 0 {
+		fmt.Printf("errors in -verify-deterministic run with shuffle seed %d:\n", seed)
+		fatalErrors(errs)
+	}
+
+	actual := &bytes.Buffer{}
+	if err := ctx.WriteBuildFile(actual); err != nil {
+		fatalf("error writing Ninja file contents in -verify-deterministic run: %s", err)
+	}
+
+	// The shards are compared first because the main Ninja file only contains their hashes.
+	if scratchDir != "" {
+		diff, err := diffShards(absolutePath(ninjaShardDir), scratchDir)
+		if err != nil {
+			fatalf("error comparing Ninja shards in -verify-deterministic run: %s", err)
+		}
+		if diff != "" {
+			fatalf("Ninja output is not deterministic, a second run visiting modules one at a time "+
+				"with shuffle seed %d produced different shards:\n%s", seed, diff)
+		}
+	}
+
+	if !bytes.Equal(expected, actual.Bytes()) {
+		fatalf("Ninja output is not deterministic, a second run visiting modules one at a time "+
+			"with shuffle seed %d produced different output:\n%s",
+			seed, diffLines(expected, actual.Bytes()))
+	}
+}
+
+// diffShards returns a description of the differences between the shard files in dir and those in
+// verifyDir, or an empty string if they are identical.
+func diffShards(dir, verifyDir string) (string, error) {
+	files, err := shardFiles(dir)
+	if err != nil {
+		return "", err
+	}
+	verifyFiles, err := shardFiles(verifyDir)
+	if err != nil {
+		return "", err
+	}
+
+	var paths []string
+	for path := range files {
+		paths = append(paths, path)
+	}
+	for path := range verifyFiles {
+		if !files[path] {
+			paths = append(paths, path)
+		}
+	}
+	sort.Strings(paths)
+
+	buf := &strings.Builder{}
+	for _, path := range paths {
+		switch {
+		case !verifyFiles[path]:
+			fmt.Fprintf(buf, "shard %s was only written by the first run\n", path)
+		case !files[path]:
+			fmt.Fprintf(buf, "shard %s was only written by the verification run\n", path)
+		default:
+			a, err := ioutil.ReadFile(filepath.Join(dir, path))
+			if err != nil {
+				return "", err
+			}
+			b, err := ioutil.ReadFile(filepath.Join(verifyDir, path))
+			if err != nil {
+				return "", err
+			}
+			if diff := diffLines(a, b); diff != "" {
+				fmt.Fprintf(buf, "shard %s:\n%s", path, diff)
+			}
+		}
+	}
+
+	return buf.String(), nil
+}
+
+// shardFiles returns the paths relative to dir of the files in dir and its subdirectories.
+func shardFiles(dir string) (map[string]bool, error) {
+	files := make(map[string]bool)
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			rel, err := filepath.Rel(dir, path)
+			if err != nil {
+				return err
+			}
+			files[rel] = true
+		}
+		return nil
+	})
+	return files, err
+}
+
+// diffLines returns a unified-style diff of the region between the first and last lines that
+// differ between a and b, or an empty string if they are identical.
+func diffLines(a, b []byte) string {
+	if bytes.Equal(a, b) {
+		return ""
+	}
+
+	aLines := splitLines(a)
+	bLines := splitLines(b)
+
+	prefix := 0
+	for prefix < len(aLines) && prefix < len(bLines) && aLines[prefix] == bLines[prefix] {
+		prefix++
+	}
+
+	suffix := 0
+	for suffix < len(aLines)-prefix && suffix < len(bLines)-prefix &&
+		aLines[len(aLines)-1-suffix] == bLines[len(bLines)-1-suffix] {
+		suffix++
+	}
+
+	start := prefix - verifyDiffContext
+	if start < 0 {
+		start = 0
+	}
+	contextAfter := suffix
+	if contextAfter > verifyDiffContext {
+		contextAfter = verifyDiffContext
+	}
+	aEnd := len(aLines) - suffix
+	bEnd := len(bLines) - suffix
+
+	buf := &strings.Builder{}
+	fmt.Fprintf(buf, "--- first run\n+++ verification run\n")
+	fmt.Fprintf(buf, "@@ -%s +%s @@\n",
+		diffRange(start, aEnd+contextAfter-start), diffRange(start, bEnd+contextAfter-start))
+
+	writeLines := func(prefix string, lines []string) {
+		for i, line := range lines {
+			if i == verifyDiffMaxLines {
+				fmt.Fprintf(buf, "%s... %d more lines\n", prefix, len(lines)-i)
+				break
+			}
+			buf.WriteString(prefix)
+			buf.WriteString(line)
+			if !strings.HasSuffix(line, "\n") {
+				buf.WriteString("\n\\ No newline at end of file\n")
+			}
+		}
+	}
+
+	writeLines(" ", aLines[start:prefix])
+	writeLines("-", aLines[prefix:aEnd])
+	writeLines("+", bLines[prefix:bEnd])
+	writeLines(" ", aLines[aEnd:aEnd+contextAfter])
+
+	return buf.String()
+}
+
+// diffRange formats the range of count lines after the first start lines for a hunk header.  An
+// empty range is described by the line before it, like diff -u does.
+func diffRange(start, count int) string {
+	if count == 0 {
+		return fmt.Sprintf("%d,0", start)
+	}
+	return fmt.Sprintf("%d,%d", start+1, count)
+}
+
+// splitLines splits b into lines that keep their newlines, so that a last line without a newline
+// differs from the same line with one.
+func splitLines(b []byte) []string {
+	lines := strings.SplitAfter(string(b), "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
@@ -0,0 +1,183 @@
+// Copyright 2020 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bootstrap
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDiffLines(t *testing.T) {
+	lines := func(n int) string {
+		var s []string
+		for i := 1; i <= n; i++ {
+			s = append(s, string(rune('a'+i-1)))
+		}
+		return strings.Join(s, "\n") + "\n"
+	}
+
+	testCases := []struct {
+		name string
+		a, b string
+		want string
+	}{
+		{
+			name: "identical",
+			a:    lines(5),
+			b:    lines(5),
+			want: "",
+		},
+		{
+			name: "empty",
+			a:    "",
+			b:    "",
+			want: "",
+		},
+		{
+			name: "empty first run",
+			a:    "",
+			b:    "a\nb\n",
+			want: "@@ -0,0 +1,2 @@\n" +
+				"+a\n" +
+				"+b\n",
+		},
+		{
+			name: "changed middle line",
+			a:    lines(10),
+			b:    strings.Replace(lines(10), "e\n", "x\n", 1),
+			want: "@@ -2,7 +2,7 @@\n" +
+				" b\n" +
+				" c\n" +
+				" d\n" +
+				"-e\n" +
+				"+x\n" +
+				" f\n" +
+				" g\n" +
+				" h\n",
+		},
+		{
+			name: "added trailing lines",
+			a:    lines(5),
+			b:    lines(7),
+			want: "@@ -3,3 +3,5 @@\n" +
+				" c\n" +
+				" d\n" +
+				" e\n" +
+				"+f\n" +
+				"+g\n",
+		},
+		{
+			name: "removed trailing lines",
+			a:    lines(7),
+			b:    lines(5),
+			want: "@@ -3,5 +3,3 @@\n" +
+				" c\n" +
+				" d\n" +
+				" e\n" +
+				"-f\n" +
+				"-g\n",
+		},
+		{
+			name: "missing final newline",
+			a:    "a\nb\n",
+			b:    "a\nb",
+			want: "@@ -1,2 +1,2 @@\n" +
+				" a\n" +
+				"-b\n" +
+				"+b\n" +
+				"\\ No newline at end of file\n",
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			want := testCase.want
+			if want != "" {
+				want = "--- first run\n+++ verification run\n" + want
+			}
+			if got := diffLines([]byte(testCase.a), []byte(testCase.b)); got != want {
+				t.Errorf("incorrect diff:\nwant:\n%s\ngot:\n%s", want, got)
+			}
+		})
+	}
+}
+
+func TestDiffShards(t *testing.T) {
+	dir, err := ioutil.TempDir("", "verify_shards_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	write := func(files map[string]string) string {
+		t.Helper()
+		shardDir, err := ioutil.TempDir(dir, "shards")
+		if err != nil {
+			t.Fatal(err)
+		}
+		for path, contents := range files {
+			path = filepath.Join(shardDir, path)
+			if err := os.MkdirAll(filepath.Dir(path), 0777); err != nil {
+				t.Fatal(err)
+			}
+			if err := ioutil.WriteFile(path, []byte(contents), 0666); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return shardDir
+	}
+
+	first := write(map[string]string{
+		"build.ninja":     "a\n",
+		"a/build.ninja":   "b\nc\n",
+		"old/build.ninja": "d\n",
+	})
+
+	diff, err := diffShards(first, write(map[string]string{
+		"build.ninja":     "a\n",
+		"a/build.ninja":   "b\nc\n",
+		"old/build.ninja": "d\n",
+	}))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if diff != "" {
+		t.Errorf("expected no differences for identical shards, got:\n%s", diff)
+	}
+
+	diff, err = diffShards(first, write(map[string]string{
+		"build.ninja":     "a\n",
+		"a/build.ninja":   "b\nx\n",
+		"new/build.ninja": "d\n",
+	}))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	want := "shard a/build.ninja:\n" +
+		"--- first run\n" +
+		"+++ verification run\n" +
+		"@@ -1,2 +1,2 @@\n" +
+		" b\n" +
+		"-c\n" +
+		"+x\n" +
+		"shard new/build.ninja was only written by the verification run\n" +
+		"shard old/build.ninja was only written by the first run\n"
+	if diff != want {
+		t.Errorf("incorrect differences:\nwant:\n%s\ngot:\n%s", want, diff)
+	}
+}
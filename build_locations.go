@@ -0,0 +1,63 @@
+// Copyright 2020 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blueprint
+
+import (
+	"fmt"
+	"path/filepath"
+	"runtime"
+	"text/scanner"
+)
+
+// SetBuildLocationComments sets whether WriteBuildFile precedes each build statement with a comment
+// containing the Blueprints file location and variant of the module, or the name of the singleton,
+// that generated it, along with the Go function and source location that called Build.  This makes
+// it possible to go straight from a failing command in the Ninja file to the code that created it.
+// It must be called before PrepareBuildActions, as the caller of Build is only recorded when it is
+// enabled.
+func (c *Context) SetBuildLocationComments(enabled bool) {
+	c.buildLocationComments = enabled
+}
+
+// buildCaller returns the name and source location of the function that called the Build method of
+// a ModuleContext or SingletonContext.  Only the base name of the source file is used so that the
+// Ninja file doesn't depend on the directory the Go code was built in.
+func buildCaller() string {
+	// Skip buildCaller and Build.
+	pc, file, line, ok := runtime.Caller(2)
+	if !ok {
+		return "unknown"
+	}
+
+	name := "unknown"
+	if f := runtime.FuncForPC(pc); f != nil {
+		name = f.Name()
+	}
+
+	return fmt.Sprintf("%s (%s:%d)", name, filepath.Base(file), line)
+}
+
+// moduleBuildLocation returns the description of a module used in the comments written by
+// SetBuildLocationComments.
+func moduleBuildLocation(module *moduleInfo, relPos scanner.Position) string {
+	if module.variantName != "" {
+		return fmt.Sprintf("%s: module %q variant %q", relPos, module.Name(), module.variantName)
+	}
+	return fmt.Sprintf("%s: module %q", relPos, module.Name())
+}
+
+func buildLocationComment(location, caller string) string {
+	return location + "\nBuilt by: " + caller
+}
@@ -0,0 +1,126 @@
+// Copyright 2020 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blueprint
+
+import (
+	"bytes"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+var (
+	buildLocationsPctx = NewPackageContext("github.com/google/blueprint/build_locations_test")
+
+	buildLocationsTouch = buildLocationsPctx.StaticRule("touch", RuleParams{
+		Command: "touch $out",
+	})
+)
+
+type buildLocationsModule struct {
+	SimpleName
+}
+
+func newBuildLocationsModule() (Module, []interface{}) {
+	m := &buildLocationsModule{}
+	return m, []interface{}{&m.SimpleName.Properties}
+}
+
+func (m *buildLocationsModule) GenerateBuildActions(ctx ModuleContext) {
+	ctx.Build(buildLocationsPctx, BuildParams{
+		Rule:    buildLocationsTouch,
+		Outputs: []string{ctx.ModuleName() + "_" + ctx.ModuleSubDir()},
+	})
+}
+
+type buildLocationsSingleton struct{}
+
+func (s *buildLocationsSingleton) GenerateBuildActions(ctx SingletonContext) {
+	ctx.Build(buildLocationsPctx, BuildParams{
+		Rule:    Phony,
+		Outputs: []string{"all"},
+	})
+}
+
+func buildLocationsVariantsMutator(ctx BottomUpMutatorContext) {
+	if ctx.ModuleName() == "b" {
+		ctx.CreateVariations("arm", "x86")
+	}
+}
+
+func runBuildLocationsTest(t *testing.T, enabled bool) string {
+	t.Helper()
+
+	ctx := NewContext()
+	ctx.SetBuildLocationComments(enabled)
+	ctx.SetNinjaLineWrapping(false)
+	ctx.RegisterModuleType("test", newBuildLocationsModule)
+	ctx.RegisterBottomUpMutator("variants", buildLocationsVariantsMutator)
+	ctx.RegisterSingletonType("singleton", func() Singleton { return &buildLocationsSingleton{} })
+	ctx.MockFileSystem(map[string][]byte{
+		"dir/Blueprints": []byte(`
+			test {
+				name: "a",
+			}
+
+			test {
+				name: "b",
+			}
+		`),
+	})
+
+	_, errs := ctx.ParseBlueprintsFiles("Blueprints", nil)
+	if len(errs) == 0 {
+		_, errs = ctx.ResolveDependencies(nil)
+	}
+	if len(errs) == 0 {
+		_, _, errs = ctx.PrepareBuildActions(nil)
+	}
+	if len(errs) > 0 {
+		t.Fatalf("unexpected errors: %q", errs)
+	}
+
+	buf := &bytes.Buffer{}
+	if err := ctx.WriteBuildFile(buf); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	return buf.String()
+}
+
+func TestBuildLocationComments(t *testing.T) {
+	out := runBuildLocationsTest(t, true)
+
+	const moduleCaller = `github\.com/google/blueprint\.\(\*buildLocationsModule\)\.GenerateBuildActions ` +
+		`\(build_locations_test\.go:\d+\)`
+	const singletonCaller = `github\.com/google/blueprint\.\(\*buildLocationsSingleton\)\.GenerateBuildActions ` +
+		`\(build_locations_test\.go:\d+\)`
+
+	for _, want := range []string{
+		`# dir/Blueprints:2:4: module "a"\n# Built by: ` + moduleCaller + `\nbuild a_: `,
+		`# dir/Blueprints:6:4: module "b" variant "arm"\n# Built by: ` + moduleCaller +
+			`\nbuild b_arm: `,
+		`# dir/Blueprints:6:4: module "b" variant "x86"\n# Built by: ` + moduleCaller +
+			`\nbuild b_x86: `,
+		`# singleton "singleton"\n# Built by: ` + singletonCaller + `\nbuild all: phony`,
+	} {
+		if !regexp.MustCompile(want).MatchString(out) {
+			t.Errorf("expected build file to match %q, got:\n%s", want, out)
+		}
+	}
+
+	if out := runBuildLocationsTest(t, false); strings.Contains(out, "Built by") {
+		t.Errorf("expected no build location comments when disabled, got:\n%s", out)
+	}
+}
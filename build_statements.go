@@ -0,0 +1,222 @@
+// Copyright 2020 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blueprint
+
+import (
+	"fmt"
+	"strings"
+)
+
+// A BuildStatement describes a build statement generated by a module or singleton with all of its
+// Ninja variables evaluated, for tests and tools that need to inspect the build actions without
+// parsing the Ninja file.
+type BuildStatement struct {
+	// Rule is the name of the rule as it appears in the Ninja file.
+	Rule string
+
+	// Command is the command of the rule with the arguments of the build statement and the $in
+	// and $out variables substituted.  It is empty for built-in rules like Phony.
+	Command string
+
+	// Description is the description of the build statement, or of the rule if the build
+	// statement does not set one, evaluated like Command.
+	Description string
+
+	// Dyndep is the dynamic dependency file of the build statement, or of the rule if the build
+	// statement does not set one, evaluated like Command.
+	Dyndep string
+
+	Comment         string
+	Outputs         []string
+	ImplicitOutputs []string
+	Inputs          []string
+	Implicits       []string
+	OrderOnly       []string
+	Validations     []string
+
+	// Args contains the evaluated value of each argument set by the build statement.
+	Args map[string]string
+
+	Optional bool
+}
+
+// ModuleBuildStatements returns the build statements generated by a module variant, in the order
+// they were generated.  If this is called before PrepareBuildActions successfully completes then
+// ErrBuildActionsNotReady is returned.
+func (c *Context) ModuleBuildStatements(logicModule Module) ([]BuildStatement, error) {
+	if !c.buildActionsReady {
+		return nil, ErrBuildActionsNotReady
+	}
+
+	module, ok := c.moduleInfo[logicModule]
+	if !ok {
+		return nil, fmt.Errorf("unknown module %v", logicModule)
+	}
+	return c.buildStatements(&module.actionDefs)
+}
+
+// SingletonBuildStatements returns the build statements generated by a singleton, in the order
+// they were generated.  If this is called before PrepareBuildActions successfully completes then
+// ErrBuildActionsNotReady is returned.
+func (c *Context) SingletonBuildStatements(singleton Singleton) ([]BuildStatement, error) {
+	if !c.buildActionsReady {
+		return nil, ErrBuildActionsNotReady
+	}
+
+	for _, info := range c.singletonInfo {
+		if info.singleton == singleton {
+			return c.buildStatements(&info.actionDefs)
+		}
+	}
+	return nil, fmt.Errorf("unknown singleton %v", singleton)
+}
+
+func (c *Context) buildStatements(actions *localBuildActions) ([]BuildStatement, error) {
+	// The local variables of the module or singleton are not in the global variables, add them
+	// to a copy along with the rule arguments of each build statement as it is evaluated.
+	variables := make(map[Variable]ninjaString, len(c.globalVariables)+len(actions.variables))
+	for v, value := range c.globalVariables {
+		variables[v] = value
+	}
+	for _, v := range actions.variables {
+		value, err := v.value(nil)
+		if err != nil {
+			return nil, err
+		}
+		variables[v] = value
+	}
+
+	var statements []BuildStatement
+	for _, def := range actions.buildDefs {
+		statement, err := evalBuildDef(def, variables, c.pkgNames)
+		if err != nil {
+			return nil, err
+		}
+		statements = append(statements, statement)
+	}
+	return statements, nil
+}
+
+// evalBuildDef evaluates a buildDef into a BuildStatement.  The rule arguments of the buildDef
+// are added to variables.
+func evalBuildDef(def *buildDef, variables map[Variable]ninjaString,
+	pkgNames map[*packageContext]string) (BuildStatement, error) {
+
+	s := BuildStatement{
+		Rule:     def.Rule.fullName(pkgNames),
+		Comment:  def.Comment,
+		Optional: def.Optional,
+	}
+
+	evalList := func(list []ninjaString) ([]string, error) {
+		var values []string
+		for _, str := range list {
+			value, err := str.Eval(variables)
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, value)
+		}
+		return values, nil
+	}
+
+	var err error
+	if s.Outputs, err = evalList(def.Outputs); err != nil {
+		return s, err
+	}
+	if s.ImplicitOutputs, err = evalList(def.ImplicitOutputs); err != nil {
+		return s, err
+	}
+	if s.Inputs, err = evalList(def.Inputs); err != nil {
+		return s, err
+	}
+	if s.Implicits, err = evalList(def.Implicits); err != nil {
+		return s, err
+	}
+	if s.OrderOnly, err = evalList(def.OrderOnly); err != nil {
+		return s, err
+	}
+	if s.Validations, err = evalList(def.Validations); err != nil {
+		return s, err
+	}
+
+	if len(def.Args) > 0 {
+		s.Args = make(map[string]string, len(def.Args))
+		for v, str := range def.Args {
+			value, err := str.Eval(variables)
+			if err != nil {
+				return s, err
+			}
+			s.Args[v.name()] = value
+		}
+	}
+
+	// evalRuleString evaluates a string from the rule definition, which can refer to the rule
+	// arguments and the built-in $in and $out variables.  Arguments that are not set by the build
+	// statement evaluate to the empty string, as they do in Ninja.
+	evalRuleString := func(str ninjaString) (string, error) {
+		for _, v := range str.Variables() {
+			if _, ok := v.(*argVariable); !ok {
+				continue
+			}
+			switch v.name() {
+			case "in":
+				variables[v] = simpleNinjaString(strings.Join(s.Inputs, " "))
+			case "out":
+				variables[v] = simpleNinjaString(strings.Join(s.Outputs, " "))
+			default:
+				if value, ok := def.Args[v]; ok {
+					variables[v] = value
+				} else {
+					variables[v] = simpleNinjaString("")
+				}
+			}
+		}
+		return str.Eval(variables)
+	}
+
+	var ruleVariables map[string]ninjaString
+	if def.RuleDef != nil {
+		ruleVariables = def.RuleDef.Variables
+	}
+
+	if command, ok := ruleVariables["command"]; ok {
+		if s.Command, err = evalRuleString(command); err != nil {
+			return s, err
+		}
+	}
+
+	description, ok := def.Variables["description"]
+	if !ok {
+		description, ok = ruleVariables["description"]
+	}
+	if ok {
+		if s.Description, err = evalRuleString(description); err != nil {
+			return s, err
+		}
+	}
+
+	dyndep, ok := def.Variables["dyndep"]
+	if !ok {
+		dyndep, ok = ruleVariables["dyndep"]
+	}
+	if ok {
+		if s.Dyndep, err = evalRuleString(dyndep); err != nil {
+			return s, err
+		}
+	}
+
+	return s, nil
+}
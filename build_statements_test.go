@@ -0,0 +1,152 @@
+// Copyright 2020 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blueprint
+
+import (
+	"reflect"
+	"testing"
+)
+
+var (
+	buildStatementsPctx = NewPackageContext("github.com/google/blueprint/build_statements_test")
+
+	buildStatementsCc = buildStatementsPctx.StaticVariable("cc", "clang")
+
+	buildStatementsCompile = buildStatementsPctx.StaticRule("compile", RuleParams{
+		Command:     "$cc $cflags -c $in -o $out",
+		Description: "compile $out",
+	}, "cflags")
+)
+
+type buildStatementsModule struct {
+	SimpleName
+}
+
+func newBuildStatementsModule() (Module, []interface{}) {
+	m := &buildStatementsModule{}
+	return m, []interface{}{&m.SimpleName.Properties}
+}
+
+func (m *buildStatementsModule) GenerateBuildActions(ctx ModuleContext) {
+	ctx.Variable(buildStatementsPctx, "outDir", "out/"+ctx.ModuleName())
+	link := ctx.Rule(buildStatementsPctx, "link", RuleParams{
+		Command: "${cc} $in -o $out $ldflags",
+	}, "ldflags")
+
+	ctx.Build(buildStatementsPctx, BuildParams{
+		Rule:      buildStatementsCompile,
+		Outputs:   []string{"${outDir}/a.o"},
+		Inputs:    []string{"a.c"},
+		Implicits: []string{"a.h"},
+		Args: map[string]string{
+			"cflags": "-O2 -I${outDir}",
+		},
+	})
+
+	ctx.Build(buildStatementsPctx, BuildParams{
+		Rule:        link,
+		Description: "link ${outDir}/a",
+		Outputs:     []string{"${outDir}/a"},
+		Inputs:      []string{"${outDir}/a.o"},
+		OrderOnly:   []string{"${outDir}/stamp"},
+	})
+}
+
+type buildStatementsSingleton struct{}
+
+func (s *buildStatementsSingleton) GenerateBuildActions(ctx SingletonContext) {
+	ctx.Build(buildStatementsPctx, BuildParams{
+		Rule:     Phony,
+		Outputs:  []string{"all"},
+		Inputs:   []string{"out/foo/a"},
+		Optional: true,
+	})
+}
+
+func TestBuildStatements(t *testing.T) {
+	ctx := NewContext()
+	ctx.RegisterModuleType("test", newBuildStatementsModule)
+	singleton := &buildStatementsSingleton{}
+	ctx.RegisterSingletonType("test_singleton", func() Singleton { return singleton })
+	ctx.MockFileSystem(map[string][]byte{
+		"Blueprints": []byte(`
+			test {
+				name: "foo",
+			}
+		`),
+	})
+
+	_, errs := ctx.ParseBlueprintsFiles("Blueprints", nil)
+	if len(errs) == 0 {
+		_, errs = ctx.ResolveDependencies(nil)
+	}
+	if len(errs) == 0 {
+		var module Module
+		ctx.VisitAllModules(func(m Module) { module = m })
+		if _, err := ctx.ModuleBuildStatements(module); err != ErrBuildActionsNotReady {
+			t.Errorf("expected ErrBuildActionsNotReady, got %v", err)
+		}
+		_, _, errs = ctx.PrepareBuildActions(nil)
+	}
+	if len(errs) > 0 {
+		t.Fatalf("unexpected errors: %q", errs)
+	}
+
+	var module Module
+	ctx.VisitAllModules(func(m Module) { module = m })
+
+	got, err := ctx.ModuleBuildStatements(module)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	want := []BuildStatement{
+		{
+			Rule:        "g.build_statements_test.compile",
+			Command:     "clang -O2 -Iout/foo -c a.c -o out/foo/a.o",
+			Description: "compile out/foo/a.o",
+			Outputs:     []string{"out/foo/a.o"},
+			Inputs:      []string{"a.c"},
+			Implicits:   []string{"a.h"},
+			Args:        map[string]string{"cflags": "-O2 -Iout/foo"},
+		},
+		{
+			Rule:        "m.foo_.link",
+			Command:     "clang out/foo/a.o -o out/foo/a ",
+			Description: "link out/foo/a",
+			Outputs:     []string{"out/foo/a"},
+			Inputs:      []string{"out/foo/a.o"},
+			OrderOnly:   []string{"out/foo/stamp"},
+		},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("incorrect module build statements:\nwant: %#v\n got: %#v", want, got)
+	}
+
+	got, err = ctx.SingletonBuildStatements(singleton)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	want = []BuildStatement{
+		{
+			Rule:     "phony",
+			Outputs:  []string{"all"},
+			Inputs:   []string{"out/foo/a"},
+			Optional: true,
+		},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("incorrect singleton build statements:\nwant: %#v\n got: %#v", want, got)
+	}
+}
@@ -21,6 +21,7 @@ import (
 	"fmt"
 	"io"
 	"io/ioutil"
+	"math/rand"
 	"os"
 	"path/filepath"
 	"reflect"
@@ -68,6 +69,8 @@ const MockModuleListFile = "bplist"
 // write phase generates the Ninja manifest text based on the generated build
 // actions.
 type Context struct {
+	// Cancelling the context.Context stops parsing, mutators, build action generation and
+	// WriteBuildFile, which return its error.  The Context may not be used after it is cancelled.
 	context.Context
 
 	// set at instantiation
@@ -108,12 +111,62 @@ type Context struct {
 
 	subninjas []string
 
+	// set during PrepareBuildActions
+	outputOwners map[string]outputOwner
+
+	// set by SingletonContext.AddDefaultTargets during PrepareBuildActions
+	defaultTargets []string
+
+	// set by SetDirectoryTargets
+	directoryTargets bool
+
+	// set by SetNinjaShards
+	ninjaShards *NinjaShards
+
+	// set by SetNinjaLineWrapping
+	noNinjaLineWrapping bool
+
+	// set by SetHoistBuildArgs
+	hoistBuildArgsMin int
+
+	// set by SetBuildLocationComments
+	buildLocationComments bool
+
 	// set lazily by sortedModuleGroups
 	cachedSortedModuleGroups []*moduleGroup
 
 	globs    map[string]GlobPath
 	globLock sync.Mutex
 
+	// set by SetHashBlueprintsFiles
+	hashBlueprintsFiles bool
+
+	// set during Parse if hashBlueprintsFiles is set
+	blueprintsHashes     map[string]string
+	blueprintsHashesLock sync.Mutex
+
+	// set by SetProfiling
+	profiler *profiler
+
+	// set by SetParallelism and SetShuffleVisitOrder
+	parallelism int
+	shuffleRand *rand.Rand
+
+	// set by SetCheckParallelMutators
+	checkParallelMutators bool
+
+	// set by RegisterDefaultsModuleType
+	defaultsMutatorRegistered bool
+
+	// set during ResolveDependencies and PrepareBuildActions.  The first resolveWarnings warnings
+	// were reported during ResolveDependencies.
+	warnings        []*Warning
+	resolveWarnings int
+
+	// set during Parse from default_visibility assignments
+	packageVisibility map[string]*packageVisibility
+	visibilityLock    sync.Mutex
+
 	srcDir         string
 	fs             pathtools.FileSystem
 	moduleListFile string
@@ -303,6 +356,12 @@ type mutatorInfo struct {
 	bottomUpMutator BottomUpMutator
 	name            string
 	parallel        bool
+	after           []string
+	before          []string
+
+	// unit is the first mutator of a group of mutators that must run one after another without
+	// any other mutator between them, or nil.
+	unit *mutatorInfo
 }
 
 func newContext() *Context {
@@ -328,6 +387,7 @@ func NewContext() *Context {
 	ctx := newContext()
 
 	ctx.RegisterBottomUpMutator("blueprint_deps", blueprintDepsMutator)
+	ctx.RegisterBottomUpMutator("blueprint_alias_deps", aliasDepsMutator).Parallel()
 
 	return ctx
 }
@@ -485,8 +545,9 @@ func singletonTypeName(singleton Singleton) string {
 
 // RegisterTopDownMutator registers a mutator that will be invoked to propagate dependency info
 // top-down between Modules.  Each registered mutator is invoked in registration order (mixing
-// TopDownMutators and BottomUpMutators) once per Module, and the invocation on any module will
-// have returned before it is in invoked on any of its dependencies.
+// TopDownMutators and BottomUpMutators), adjusted to satisfy any constraints declared with
+// MutatorHandle.After and MutatorHandle.Before, once per Module, and the invocation on any module
+// will have returned before it is in invoked on any of its dependencies.
 //
 // The mutator type names given here must be unique to all top down mutators in
 // the Context.
@@ -512,8 +573,9 @@ func (c *Context) RegisterTopDownMutator(name string, mutator TopDownMutator) Mu
 
 // RegisterBottomUpMutator registers a mutator that will be invoked to split Modules into variants.
 // Each registered mutator is invoked in registration order (mixing TopDownMutators and
-// BottomUpMutators) once per Module, will not be invoked on a module until the invocations on all
-// of the modules dependencies have returned.
+// BottomUpMutators), adjusted to satisfy any constraints declared with MutatorHandle.After and
+// MutatorHandle.Before, once per Module, will not be invoked on a module until the invocations on
+// all of the modules dependencies have returned.
 //
 // The mutator type names given here must be unique to all bottom up or early
 // mutators in the Context.
@@ -543,6 +605,14 @@ type MutatorHandle interface {
 	// method on the mutator context is thread-safe, but the mutator must handle synchronization
 	// for any modifications to global state or any modules outside the one it was invoked on.
 	Parallel() MutatorHandle
+
+	// After declares that the mutator must run after the mutators registered with
+	// RegisterTopDownMutator or RegisterBottomUpMutator with any of the given names.
+	After(names ...string) MutatorHandle
+
+	// Before declares that the mutator must run before the mutators registered with
+	// RegisterTopDownMutator or RegisterBottomUpMutator with any of the given names.
+	Before(names ...string) MutatorHandle
 }
 
 func (mutator *mutatorInfo) Parallel() MutatorHandle {
@@ -550,6 +620,16 @@ func (mutator *mutatorInfo) Parallel() MutatorHandle {
 	return mutator
 }
 
+func (mutator *mutatorInfo) After(names ...string) MutatorHandle {
+	mutator.after = append(mutator.after, names...)
+	return mutator
+}
+
+func (mutator *mutatorInfo) Before(names ...string) MutatorHandle {
+	mutator.before = append(mutator.before, names...)
+	return mutator
+}
+
 // RegisterEarlyMutator registers a mutator that will be invoked to split
 // Modules into multiple variant Modules before any dependencies have been
 // created.  Each registered mutator is invoked in registration order once
@@ -668,6 +748,9 @@ func (c *Context) ParseFileList(rootDir string, filePaths []string,
 		return nil, []error{fmt.Errorf("no paths provided to parse")}
 	}
 
+	span := c.profiler.begin("Parse", "phase")
+	defer span.end(nil)
+
 	c.dependenciesReady = false
 
 	type newModuleInfo struct {
@@ -683,7 +766,7 @@ func (c *Context) ParseFileList(rootDir string, filePaths []string,
 
 	// handler must be reentrant
 	handleOneFile := func(file *parser.File) {
-		if atomic.LoadUint32(&numErrs) > maxErrors {
+		if atomic.LoadUint32(&numErrs) > maxErrors || c.Context.Err() != nil {
 			return
 		}
 
@@ -848,7 +931,7 @@ func (c *Context) WalkBlueprintsFiles(rootDir string, filePaths []string,
 				<-blueprint.parent.doneVisiting
 			}
 
-			if len(errs) == 0 {
+			if len(errs) == 0 && c.Context.Err() == nil {
 				// process this file
 				visitor(file)
 			}
@@ -884,6 +967,8 @@ loop:
 		if len(errs) > maxErrors {
 			tooManyErrors = true
 		}
+		// Stop starting new files once there are too many errors or the context is cancelled
+		stop := tooManyErrors || c.Context.Err() != nil
 
 		select {
 		case newErrs := <-errsCh:
@@ -891,16 +976,16 @@ loop:
 		case dep := <-depsCh:
 			deps = append(deps, dep)
 		case blueprint := <-blueprintsCh:
-			if tooManyErrors {
+			if stop {
 				continue
 			}
 			foundParseableBlueprint(blueprint)
 		case blueprint := <-doneParsingCh:
 			activeCount--
-			if !tooManyErrors {
+			if !stop {
 				startParseDescendants(blueprint)
 			}
-			if activeCount < maxActiveCount && len(pending) > 0 {
+			if c.Context.Err() == nil && activeCount < maxActiveCount && len(pending) > 0 {
 				// start to process the next one from the queue
 				next := pending[len(pending)-1]
 				pending = pending[:len(pending)-1]
@@ -917,6 +1002,10 @@ loop:
 	// wait for every visitor() to complete
 	visitorWaitGroup.Wait()
 
+	if err := c.Context.Err(); err != nil {
+		return nil, append(errs, err)
+	}
+
 	return
 }
 
@@ -983,7 +1072,11 @@ func (c *Context) openAndParse(filename string, scope *parser.Scope, rootDir str
 				errs = append(errs, err)
 			}
 		}()
-		file, subBlueprints, errs = c.parseOne(rootDir, filename, f, scope, parent)
+		reader, recordHash := c.hashBlueprintsReader(filename, f)
+		file, subBlueprints, errs = c.parseOne(rootDir, filename, reader, scope, parent)
+		if len(errs) == 0 {
+			recordHash()
+		}
 	}()
 
 	if len(errs) > 0 {
@@ -1019,6 +1112,7 @@ func (c *Context) parseOne(rootDir, filename string, reader io.Reader,
 	scope.Remove("subdirs")
 	scope.Remove("optional_subdirs")
 	scope.Remove("build")
+	scope.Remove(defaultVisibilityVariable)
 	file, errs = parser.ParseAndEval(filename, reader, scope)
 	if len(errs) > 0 {
 		for i, err := range errs {
@@ -1050,6 +1144,13 @@ func (c *Context) parseOne(rootDir, filename string, reader io.Reader,
 		}
 	}
 
+	defaultVisibility, defaultVisibilityPos, err := getLocalStringListFromScope(scope, defaultVisibilityVariable)
+	if err != nil {
+		errs = append(errs, err)
+	} else if defaultVisibility != nil {
+		c.setPackageVisibility(filepath.Dir(relBlueprintsFile), defaultVisibility, defaultVisibilityPos)
+	}
+
 	subBlueprintsName, _, err := getStringFromScope(scope, "subname")
 	if err != nil {
 		errs = append(errs, err)
@@ -1225,7 +1326,7 @@ func (c *Context) cloneLogicModule(origModule *moduleInfo) (Module, []interface{
 }
 
 func (c *Context) createVariations(origModule *moduleInfo, mutatorName string,
-	defaultVariationName *string, variationNames []string) ([]*moduleInfo, []error) {
+	depChooser depChooser, variationNames []string) ([]*moduleInfo, []error) {
 
 	if len(variationNames) == 0 {
 		panic(fmt.Errorf("mutator %q passed zero-length variation list for module %q",
@@ -1273,7 +1374,7 @@ func (c *Context) createVariations(origModule *moduleInfo, mutatorName string,
 
 		newModules = append(newModules, newModule)
 
-		newErrs := c.convertDepsToVariation(newModule, mutatorName, variationName, defaultVariationName)
+		newErrs := c.convertDepsToVariation(newModule, depChooser)
 		if len(newErrs) > 0 {
 			errs = append(errs, newErrs...)
 		}
@@ -1289,31 +1390,59 @@ func (c *Context) createVariations(origModule *moduleInfo, mutatorName string,
 	return newModules, errs
 }
 
-func (c *Context) convertDepsToVariation(module *moduleInfo,
-	mutatorName, variationName string, defaultVariationName *string) (errs []error) {
+// A depChooser selects which of the variants of a dependency that was split by the current mutator
+// should be used by module.  It returns the chosen variant, or nil and the name of the variation
+// that could not be found.
+type depChooser func(module *moduleInfo, dep depInfo) (*moduleInfo, string)
 
+// chooseDep returns the variant in candidates with the given variation for mutatorName, falling
+// back to defaultVariationName if it is set.
+func chooseDep(candidates []*moduleInfo, mutatorName, variationName string,
+	defaultVariationName *string) (*moduleInfo, string) {
+
+	for _, m := range candidates {
+		if m.variant[mutatorName] == variationName {
+			return m, ""
+		}
+	}
+
+	if defaultVariationName != nil {
+		// give it a second chance; match with defaultVariationName
+		for _, m := range candidates {
+			if m.variant[mutatorName] == *defaultVariationName {
+				return m, ""
+			}
+		}
+	}
+
+	return nil, variationName
+}
+
+// chooseDepInherit returns a depChooser that selects the variant of the dependency with the same
+// variation for mutatorName as the depending module.
+func chooseDepInherit(mutatorName string, defaultVariationName *string) depChooser {
+	return func(module *moduleInfo, dep depInfo) (*moduleInfo, string) {
+		return chooseDep(dep.module.splitModules, mutatorName, module.variant[mutatorName],
+			defaultVariationName)
+	}
+}
+
+// chooseDepExplicit returns a depChooser that selects the variant of the dependency with the given
+// variation for mutatorName.
+func chooseDepExplicit(mutatorName, variationName string, defaultVariationName *string) depChooser {
+	return func(module *moduleInfo, dep depInfo) (*moduleInfo, string) {
+		return chooseDep(dep.module.splitModules, mutatorName, variationName, defaultVariationName)
+	}
+}
+
+func (c *Context) convertDepsToVariation(module *moduleInfo, depChooser depChooser) (errs []error) {
 	for i, dep := range module.directDeps {
 		if dep.module.logicModule == nil {
-			var newDep *moduleInfo
-			for _, m := range dep.module.splitModules {
-				if m.variant[mutatorName] == variationName {
-					newDep = m
-					break
-				}
-			}
-			if newDep == nil && defaultVariationName != nil {
-				// give it a second chance; match with defaultVariationName
-				for _, m := range dep.module.splitModules {
-					if m.variant[mutatorName] == *defaultVariationName {
-						newDep = m
-						break
-					}
-				}
-			}
+			newDep, missingVariation := depChooser(module, dep)
 			if newDep == nil {
 				errs = append(errs, &BlueprintError{
 					Err: fmt.Errorf("failed to find variation %q for module %q needed by %q",
-						variationName, dep.module.Name(), module.Name()),
+						missingVariation, dep.module.Name(), module.Name()),
 					Pos: module.pos,
 				})
 				continue
@@ -1454,6 +1583,19 @@ func (c *Context) ResolveDependencies(config interface{}) (deps []string, errs [
 
 func (c *Context) resolveDependencies(ctx context.Context, config interface{}) (deps []string, errs []error) {
 	pprof.Do(ctx, pprof.Labels("blueprint", "ResolveDependencies"), func(ctx context.Context) {
+		span := c.profiler.begin("ResolveDependencies", "phase")
+		defer span.end(nil)
+
+		c.warnings = nil
+		defer func() { c.resolveWarnings = len(c.warnings) }()
+
+		if n, ok := c.nameInterface.(interface{ verifyNamespaces() []error }); ok {
+			errs = n.verifyNamespaces()
+			if len(errs) > 0 {
+				return
+			}
+		}
+
 		c.liveGlobals = newLiveTracker(config)
 
 		deps, errs = c.generateSingletonBuildActions(config, c.preSingletonInfo, c.liveGlobals)
@@ -1473,7 +1615,16 @@ func (c *Context) resolveDependencies(ctx context.Context, config interface{}) (
 		}
 		deps = append(deps, mutatorDeps...)
 
+		errs = c.checkVisibility()
+		if len(errs) > 0 {
+			return
+		}
+
 		c.cloneModules()
+		if err := c.Context.Err(); err != nil {
+			errs = []error{err}
+			return
+		}
 
 		c.dependenciesReady = true
 	})
@@ -1555,7 +1706,21 @@ func (c *Context) addDependency(module *moduleInfo, tag DependencyTag, depName s
 		return c.discoveredMissingDependencies(module, depName)
 	}
 
+	possibleDeps, aliasVariations, errs := c.resolveAliases(module, possibleDeps, depName)
+	if possibleDeps == nil {
+		return errs
+	}
+	if len(aliasVariations) > 0 {
+		return c.addVariationDependencyOnGroup(module, possibleDeps, aliasVariations, tag, depName, false)
+	}
+
 	if m := c.findMatchingVariant(module, possibleDeps, false); m != nil {
+		if m == module {
+			return []error{&BlueprintError{
+				Err: fmt.Errorf("%q depends on itself through an alias", depName),
+				Pos: module.pos,
+			}}
+		}
 		module.newDirectDeps = append(module.newDirectDeps, depInfo{m, tag})
 		atomic.AddUint32(&c.depsModified, 1)
 		return nil
@@ -1592,7 +1757,19 @@ func (c *Context) findReverseDependency(module *moduleInfo, destName string) (*m
 		}}
 	}
 
-	if m := c.findMatchingVariant(module, possibleDeps, true); m != nil {
+	possibleDeps, aliasVariations, errs := c.resolveAliases(module, possibleDeps, destName)
+	if possibleDeps == nil {
+		if len(errs) == 0 {
+			// Missing dependencies are allowed, handle them like a missing variant below.
+			return module, nil
+		}
+		return nil, errs
+	}
+	if len(aliasVariations) > 0 {
+		if m, _ := c.findVariant(module, possibleDeps, aliasVariations, false, true); m != nil {
+			return m, nil
+		}
+	} else if m := c.findMatchingVariant(module, possibleDeps, true); m != nil {
 		return m, nil
 	}
 
@@ -1671,6 +1848,21 @@ func (c *Context) addVariationDependency(module *moduleInfo, variations []Variat
 		return c.discoveredMissingDependencies(module, depName)
 	}
 
+	possibleDeps, aliasVariations, errs := c.resolveAliases(module, possibleDeps, depName)
+	if possibleDeps == nil {
+		return errs
+	}
+	// The variations selected by the alias take precedence over the requested variations.
+	variations = append(append([]Variation(nil), variations...), aliasVariations...)
+
+	return c.addVariationDependencyOnGroup(module, possibleDeps, variations, tag, depName, far)
+}
+
+// addVariationDependencyOnGroup adds a dependency from module on the variant of possibleDeps
+// selected by variations.
+func (c *Context) addVariationDependencyOnGroup(module *moduleInfo, possibleDeps *moduleGroup,
+	variations []Variation, tag DependencyTag, depName string, far bool) []error {
+
 	foundDep, newVariant := c.findVariant(module, possibleDeps, variations, far, false)
 
 	if foundDep == nil {
@@ -1844,15 +2036,21 @@ var (
 	topDownVisitor  topDownVisitorImpl
 )
 
-// Calls visit on each module, guaranteeing that visit is not called on a module until visit on all
-// of its dependencies has finished.
+// parallelVisit calls visit on each module in parallel, while maintaining the ordering required by
+// order.  It stops visiting new modules when visit returns true or the Context's context.Context
+// is cancelled, and returns after all calls to visit that were started have returned.  Callers
+// must check c.Context.Err() to find out if the visit was stopped by cancellation.
 func (c *Context) parallelVisit(order visitOrderer, visit func(group *moduleInfo) bool) {
 	doneCh := make(chan *moduleInfo)
 	cancelCh := make(chan bool)
+	contextDoneCh := c.Context.Done()
 	count := 0
 	cancel := false
 	var backlog []*moduleInfo
-	const limit = 1000
+	limit := 1000
+	if c.parallelism > 0 {
+		limit = c.parallelism
+	}
 
 	for _, module := range c.modulesSorted {
 		module.waitingCount = order.waitCount(module)
@@ -1873,26 +2071,44 @@ func (c *Context) parallelVisit(order visitOrderer, visit func(group *moduleInfo
 		}
 	}
 
+	// nextBacklog removes a module from the backlog, at random if the visit order is shuffled.
+	nextBacklog := func() *moduleInfo {
+		i := 0
+		if c.shuffleRand != nil {
+			i = c.shuffleRand.Intn(len(backlog))
+		}
+		toVisit := backlog[i]
+		backlog = append(backlog[:i], backlog[i+1:]...)
+		return toVisit
+	}
+
+	var ready []*moduleInfo
 	for _, module := range c.modulesSorted {
 		if module.waitingCount == 0 {
-			visitOne(module)
+			ready = append(ready, module)
 		}
 	}
+	for _, module := range c.shuffleModules(ready) {
+		visitOne(module)
+	}
 
 	for count > 0 || len(backlog) > 0 {
 		select {
 		case <-cancelCh:
 			cancel = true
 			backlog = nil
+		case <-contextDoneCh:
+			cancel = true
+			backlog = nil
+			// The channel stays closed, stop selecting on it.
+			contextDoneCh = nil
 		case doneModule := <-doneCh:
 			count--
 			if !cancel {
 				for count < limit && len(backlog) > 0 {
-					toVisit := backlog[0]
-					backlog = backlog[1:]
-					visitOne(toVisit)
+					visitOne(nextBacklog())
 				}
-				for _, module := range order.propagate(doneModule) {
+				for _, module := range c.shuffleModules(order.propagate(doneModule)) {
 					module.waitingCount--
 					if module.waitingCount == 0 {
 						visitOne(module)
@@ -1903,6 +2119,33 @@ func (c *Context) parallelVisit(order visitOrderer, visit func(group *moduleInfo
 	}
 }
 
+// shuffleModules returns modules in a random order if the visit order is shuffled, otherwise it
+// returns modules unmodified.
+func (c *Context) shuffleModules(modules []*moduleInfo) []*moduleInfo {
+	if c.shuffleRand == nil {
+		return modules
+	}
+	shuffled := append([]*moduleInfo(nil), modules...)
+	c.shuffleRand.Shuffle(len(shuffled), func(i, j int) {
+		shuffled[i], shuffled[j] = shuffled[j], shuffled[i]
+	})
+	return shuffled
+}
+
+// SetParallelism sets the maximum number of modules that are visited in parallel by parallel
+// mutators and when generating build actions.  A value of 0 uses the default.
+func (c *Context) SetParallelism(parallelism int) {
+	c.parallelism = parallelism
+}
+
+// SetShuffleVisitOrder makes parallel mutators and build action generation visit modules whose
+// dependencies have all been visited in a random order chosen by seed.  The ordering between a
+// module and its dependencies is unaffected.  It can be used with SetParallelism to check that the
+// build actions do not depend on the order modules are visited in.
+func (c *Context) SetShuffleVisitOrder(seed int64) {
+	c.shuffleRand = rand.New(rand.NewSource(seed))
+}
+
 // updateDependencies recursively walks the module dependency graph and updates
 // additional fields based on the dependencies.  It builds a sorted list of modules
 // such that dependencies of a module always appear first, and populates reverse
@@ -2035,9 +2278,24 @@ func (c *Context) updateDependencies() (errs []error) {
 // by the modules and singletons via the ModuleContext.AddNinjaFileDeps(),
 // SingletonContext.AddNinjaFileDeps(), and PackageContext.AddNinjaFileDeps()
 // methods.
-func (c *Context) PrepareBuildActions(config interface{}) (deps []string, errs []error) {
+//
+// The returned warnings are those reported by mutators while resolving the
+// dependencies and by modules and singletons while generating the build
+// actions, as returned by Warnings.  They are returned even if there are
+// errors.
+func (c *Context) PrepareBuildActions(config interface{}) (deps []string, warnings []*Warning,
+	errs []error) {
+
 	pprof.Do(c.Context, pprof.Labels("blueprint", "PrepareBuildActions"), func(ctx context.Context) {
+		span := c.profiler.begin("PrepareBuildActions", "phase")
+		defer span.end(nil)
+		defer func() { warnings = c.Warnings() }()
+
 		c.buildActionsReady = false
+		c.defaultTargets = nil
+		// Discard the warnings of any earlier call to PrepareBuildActions, but keep those of
+		// ResolveDependencies.
+		c.warnings = c.warnings[:c.resolveWarnings]
 
 		if !c.dependenciesReady {
 			var extraDeps []string
@@ -2083,33 +2341,74 @@ func (c *Context) PrepareBuildActions(config interface{}) (deps []string, errs [
 		c.globalPools = c.liveGlobals.pools
 		c.globalRules = c.liveGlobals.rules
 
+		errs = c.checkNinjaFeatures()
+		if len(errs) > 0 {
+			return
+		}
+
+		c.outputOwners, errs = c.indexOutputs()
+		if len(errs) > 0 {
+			return
+		}
+
+		if c.hoistBuildArgsMin > 0 {
+			c.hoistBuildArgs()
+		}
+
 		c.buildActionsReady = true
 	})
 
 	if len(errs) > 0 {
-		return nil, errs
+		return nil, warnings, errs
 	}
 
-	return deps, nil
+	return deps, warnings, nil
 }
 
 func (c *Context) runMutators(ctx context.Context, config interface{}) (deps []string, errs []error) {
+	sorted, errs := c.sortedMutators()
+	if len(errs) > 0 {
+		return nil, errs
+	}
+
 	var mutators []*mutatorInfo
 
 	pprof.Do(ctx, pprof.Labels("blueprint", "runMutators"), func(ctx context.Context) {
 		mutators = append(mutators, c.earlyMutatorInfo...)
-		mutators = append(mutators, c.mutatorInfo...)
+		mutators = append(mutators, sorted...)
 
 		for _, mutator := range mutators {
+			if err := c.Context.Err(); err != nil {
+				errs = []error{err}
+				return
+			}
+
 			pprof.Do(ctx, pprof.Labels("mutator", mutator.name), func(context.Context) {
-				var newDeps []string
+				var direction mutatorDirection
 				if mutator.topDownMutator != nil {
-					newDeps, errs = c.runMutator(config, mutator, topDownMutator)
+					direction = topDownMutator
 				} else if mutator.bottomUpMutator != nil {
-					newDeps, errs = c.runMutator(config, mutator, bottomUpMutator)
+					direction = bottomUpMutator
 				} else {
 					panic("no mutator set on " + mutator.name)
 				}
+
+				var modulesBefore int
+				span := c.profiler.begin(mutator.name, "mutator")
+				if span != nil {
+					modulesBefore = c.moduleCount()
+				}
+
+				var newDeps []string
+				newDeps, errs = c.runMutator(config, mutator, direction)
+
+				if span != nil {
+					span.end(map[string]interface{}{
+						"direction":      direction.String(),
+						"modules_before": modulesBefore,
+						"modules_after":  c.moduleCount(),
+					})
+				}
 				if len(errs) > 0 {
 					return
 				}
@@ -2186,12 +2485,14 @@ func (c *Context) runMutator(config interface{}, mutator *mutatorInfo,
 		replace    []replace
 		newModules []*moduleInfo
 		deps       []string
+		warnings   []*Warning
 	}
 
 	reverseDeps := make(map[*moduleInfo][]depInfo)
 	var rename []rename
 	var replace []replace
 	var newModules []*moduleInfo
+	var warnings []*Warning
 
 	errsCh := make(chan []error)
 	globalStateCh := make(chan globalStateChange)
@@ -2200,11 +2501,20 @@ func (c *Context) runMutator(config interface{}, mutator *mutatorInfo,
 
 	c.depsModified = 0
 
+	var sandbox *mutatorSandbox
+	if mutator.parallel && c.checkParallelMutators {
+		sandbox = newMutatorSandbox(mutator.name, c.modulesSorted)
+	}
+
 	visit := func(module *moduleInfo) bool {
 		if module.splitModules != nil {
 			panic("split module found in sorted module list")
 		}
 
+		if c.Context.Err() != nil {
+			return true
+		}
+
 		mctx := &mutatorContext{
 			baseModuleContext: baseModuleContext{
 				context: c,
@@ -2214,6 +2524,7 @@ func (c *Context) runMutator(config interface{}, mutator *mutatorInfo,
 			name: mutator.name,
 		}
 
+		span := c.profiler.beginModule(module, "mutator", mutator.name)
 		func() {
 			defer func() {
 				if r := recover(); r != nil {
@@ -2228,6 +2539,11 @@ func (c *Context) runMutator(config interface{}, mutator *mutatorInfo,
 			}()
 			direction.run(mutator, mctx)
 		}()
+		span.end(nil)
+
+		for _, err := range sandbox.afterVisit(module) {
+			mctx.error(err)
+		}
 
 		if len(mctx.errs) > 0 {
 			errsCh <- mctx.errs
@@ -2238,13 +2554,14 @@ func (c *Context) runMutator(config interface{}, mutator *mutatorInfo,
 			newVariationsCh <- mctx.newVariations
 		}
 
-		if len(mctx.reverseDeps) > 0 || len(mctx.replace) > 0 || len(mctx.rename) > 0 || len(mctx.newModules) > 0 || len(mctx.ninjaFileDeps) > 0 {
+		if len(mctx.reverseDeps) > 0 || len(mctx.replace) > 0 || len(mctx.rename) > 0 || len(mctx.newModules) > 0 || len(mctx.ninjaFileDeps) > 0 || len(mctx.warnings) > 0 {
 			globalStateCh <- globalStateChange{
 				reverse:    mctx.reverseDeps,
 				replace:    mctx.replace,
 				rename:     mctx.rename,
 				newModules: mctx.newModules,
 				deps:       mctx.ninjaFileDeps,
+				warnings:   mctx.warnings,
 			}
 		}
 
@@ -2265,6 +2582,7 @@ func (c *Context) runMutator(config interface{}, mutator *mutatorInfo,
 				rename = append(rename, globalStateChange.rename...)
 				newModules = append(newModules, globalStateChange.newModules...)
 				deps = append(deps, globalStateChange.deps...)
+				warnings = append(warnings, globalStateChange.warnings...)
 			case newVariations := <-newVariationsCh:
 				for _, m := range newVariations {
 					newModuleInfo[m.logicModule] = m
@@ -2275,7 +2593,9 @@ func (c *Context) runMutator(config interface{}, mutator *mutatorInfo,
 		}
 	}()
 
-	if mutator.parallel {
+	// The sandbox visits modules one at a time so that modifications to other modules can be
+	// attributed to the visit that made them.
+	if mutator.parallel && sandbox == nil {
 		c.parallelVisit(direction.orderer(), visit)
 	} else {
 		direction.orderer().visit(c.modulesSorted, visit)
@@ -2287,6 +2607,12 @@ func (c *Context) runMutator(config interface{}, mutator *mutatorInfo,
 		return nil, errs
 	}
 
+	if err := c.Context.Err(); err != nil {
+		return nil, []error{err}
+	}
+
+	c.warnings = append(c.warnings, warnings...)
+
 	c.moduleInfo = newModuleInfo
 
 	for _, group := range c.moduleGroups {
@@ -2436,10 +2762,12 @@ func (c *Context) generateModuleBuildActions(config interface{},
 
 	var deps []string
 	var errs []error
+	var warnings []*Warning
 
 	cancelCh := make(chan struct{})
 	errsCh := make(chan []error)
 	depsCh := make(chan []string)
+	warningsCh := make(chan []*Warning)
 
 	go func() {
 		for {
@@ -2451,12 +2779,17 @@ func (c *Context) generateModuleBuildActions(config interface{},
 				errs = append(errs, newErrs...)
 			case newDeps := <-depsCh:
 				deps = append(deps, newDeps...)
+			case newWarnings := <-warningsCh:
+				warnings = append(warnings, newWarnings...)
 
 			}
 		}
 	}()
 
 	c.parallelVisit(bottomUpVisitor, func(module *moduleInfo) bool {
+		if c.Context.Err() != nil {
+			return true
+		}
 
 		uniqueName := c.nameInterface.UniqueName(newNamespaceContext(module), module.group.name)
 		sanitizedName := toNinjaName(uniqueName)
@@ -2478,6 +2811,7 @@ func (c *Context) generateModuleBuildActions(config interface{},
 			handledMissingDeps: module.missingDeps == nil,
 		}
 
+		span := c.profiler.beginModule(module, "GenerateBuildActions", "")
 		func() {
 			defer func() {
 				if r := recover(); r != nil {
@@ -2492,6 +2826,11 @@ func (c *Context) generateModuleBuildActions(config interface{},
 			}()
 			mctx.module.logicModule.GenerateBuildActions(mctx)
 		}()
+		span.end(nil)
+
+		if len(mctx.warnings) > 0 {
+			warningsCh <- mctx.warnings
+		}
 
 		if len(mctx.errs) > 0 {
 			errsCh <- mctx.errs
@@ -2521,6 +2860,12 @@ func (c *Context) generateModuleBuildActions(config interface{},
 	cancelCh <- struct{}{}
 	<-cancelCh
 
+	if err := c.Context.Err(); err != nil {
+		return nil, []error{err}
+	}
+
+	c.warnings = append(c.warnings, warnings...)
+
 	return deps, errs
 }
 
@@ -2531,6 +2876,10 @@ func (c *Context) generateSingletonBuildActions(config interface{},
 	var errs []error
 
 	for _, info := range singletons {
+		if err := c.Context.Err(); err != nil {
+			return nil, []error{err}
+		}
+
 		// The parent scope of the singletonContext's local scope gets overridden to be that of the
 		// calling Go package on a per-call basis.  Since the initial parent scope doesn't matter we
 		// just set it to nil.
@@ -2544,6 +2893,7 @@ func (c *Context) generateSingletonBuildActions(config interface{},
 			globals: liveGlobals,
 		}
 
+		span := c.profiler.begin(info.name, "singleton")
 		func() {
 			defer func() {
 				if r := recover(); r != nil {
@@ -2558,6 +2908,9 @@ func (c *Context) generateSingletonBuildActions(config interface{},
 			}()
 			info.singleton.GenerateBuildActions(sctx)
 		}()
+		span.end(nil)
+
+		c.warnings = append(c.warnings, sctx.warnings...)
 
 		if len(sctx.errs) > 0 {
 			errs = append(errs, sctx.errs...)
@@ -3053,6 +3406,20 @@ func (c *Context) ModuleSubDir(logicModule Module) string {
 	return module.variantName
 }
 
+// ModuleVariations returns the variations that were used to create the variant of the module,
+// sorted by mutator name.
+func (c *Context) ModuleVariations(logicModule Module) []Variation {
+	module := c.moduleInfo[logicModule]
+	var variations []Variation
+	for mutator, variation := range module.variant {
+		variations = append(variations, Variation{Mutator: mutator, Variation: variation})
+	}
+	sort.Slice(variations, func(i, j int) bool {
+		return variations[i].Mutator < variations[j].Mutator
+	})
+	return variations
+}
+
 func (c *Context) ModuleType(logicModule Module) string {
 	module := c.moduleInfo[logicModule]
 	return module.typeName
@@ -3193,18 +3560,39 @@ func (c *Context) SingletonName(singleton Singleton) string {
 	return ""
 }
 
+// SetNinjaLineWrapping sets whether WriteBuildFile wraps long build statements and comments to make
+// the Ninja file easier to read.  Wrapping is enabled by default, disabling it makes writing large
+// Ninja files faster.
+func (c *Context) SetNinjaLineWrapping(wrap bool) {
+	c.noNinjaLineWrapping = !wrap
+}
+
+// newNinjaWriter returns a ninjaWriter for w that wraps lines as set by SetNinjaLineWrapping.
+func (c *Context) newNinjaWriter(w io.Writer) *ninjaWriter {
+	nw := newNinjaWriter(w)
+	nw.SetLineWrapping(!c.noNinjaLineWrapping)
+	return nw
+}
+
 // WriteBuildFile writes the Ninja manifeset text for the generated build
 // actions to w.  If this is called before PrepareBuildActions successfully
 // completes then ErrBuildActionsNotReady is returned.
 func (c *Context) WriteBuildFile(w io.Writer) error {
 	var err error
 	pprof.Do(c.Context, pprof.Labels("blueprint", "WriteBuildFile"), func(ctx context.Context) {
+		span := c.profiler.begin("WriteBuildFile", "phase")
+		defer span.end(nil)
+
 		if !c.buildActionsReady {
 			err = ErrBuildActionsNotReady
 			return
 		}
 
-		nw := newNinjaWriter(w)
+		if err = c.Context.Err(); err != nil {
+			return
+		}
+
+		nw := c.newNinjaWriter(w)
 
 		err = c.writeBuildFileHeader(nw)
 		if err != nil {
@@ -3243,7 +3631,11 @@ func (c *Context) WriteBuildFile(w io.Writer) error {
 			return
 		}
 
-		err = c.writeAllModuleActions(nw)
+		if c.ninjaShards != nil {
+			err = c.writeNinjaShards(nw)
+		} else {
+			err = c.writeAllModuleActions(nw)
+		}
 		if err != nil {
 			return
 		}
@@ -3252,6 +3644,18 @@ func (c *Context) WriteBuildFile(w io.Writer) error {
 		if err != nil {
 			return
 		}
+
+		err = c.writeDirectoryTargets(nw)
+		if err != nil {
+			return
+		}
+
+		err = c.writeDefaultTargets(nw)
+		if err != nil {
+			return
+		}
+
+		err = nw.Flush()
 	})
 
 	if err != nil {
@@ -3506,6 +3910,22 @@ func (s depSorter) Swap(i, j int) {
 	s[i], s[j] = s[j], s[i]
 }
 
+// modulesSortedByName returns all module variants sorted by name and variant, the order that they
+// are written to the Ninja file.
+func (c *Context) modulesSortedByName() []*moduleInfo {
+	modules := make([]*moduleInfo, 0, len(c.moduleInfo))
+	for _, module := range c.moduleInfo {
+		modules = append(modules, module)
+	}
+	sort.Sort(moduleSorter{modules, c.nameInterface})
+	return modules
+}
+
+// hasBuildActions returns true if the module defined any variables, rules or build statements.
+func (m *moduleInfo) hasBuildActions() bool {
+	return len(m.actionDefs.variables)+len(m.actionDefs.rules)+len(m.actionDefs.buildDefs) > 0
+}
+
 type moduleSorter struct {
 	modules       []*moduleInfo
 	nameInterface NameInterface
@@ -3536,6 +3956,12 @@ func (s moduleSorter) Swap(i, j int) {
 }
 
 func (c *Context) writeAllModuleActions(nw *ninjaWriter) error {
+	return c.writeModuleActions(nw, c.modulesSortedByName())
+}
+
+// writeModuleActions writes the build actions of each of the given modules, preceded by a comment
+// describing the module.
+func (c *Context) writeModuleActions(nw *ninjaWriter, modules []*moduleInfo) error {
 	headerTemplate := template.New("moduleHeader")
 	_, err := headerTemplate.Parse(moduleHeaderTemplate)
 	if err != nil {
@@ -3543,16 +3969,14 @@ func (c *Context) writeAllModuleActions(nw *ninjaWriter) error {
 		panic(err)
 	}
 
-	modules := make([]*moduleInfo, 0, len(c.moduleInfo))
-	for _, module := range c.moduleInfo {
-		modules = append(modules, module)
-	}
-	sort.Sort(moduleSorter{modules, c.nameInterface})
-
 	buf := bytes.NewBuffer(nil)
 
 	for _, module := range modules {
-		if len(module.actionDefs.variables)+len(module.actionDefs.rules)+len(module.actionDefs.buildDefs) == 0 {
+		if err := c.Context.Err(); err != nil {
+			return err
+		}
+
+		if !module.hasBuildActions() {
 			continue
 		}
 
@@ -3590,7 +4014,7 @@ func (c *Context) writeAllModuleActions(nw *ninjaWriter) error {
 			return err
 		}
 
-		err = c.writeLocalBuildActions(nw, &module.actionDefs)
+		err = c.writeLocalBuildActions(nw, &module.actionDefs, moduleBuildLocation(module, relPos))
 		if err != nil {
 			return err
 		}
@@ -3615,6 +4039,10 @@ func (c *Context) writeAllSingletonActions(nw *ninjaWriter) error {
 	buf := bytes.NewBuffer(nil)
 
 	for _, info := range c.singletonInfo {
+		if err := c.Context.Err(); err != nil {
+			return err
+		}
+
 		if len(info.actionDefs.variables)+len(info.actionDefs.rules)+len(info.actionDefs.buildDefs) == 0 {
 			continue
 		}
@@ -3644,7 +4072,7 @@ func (c *Context) writeAllSingletonActions(nw *ninjaWriter) error {
 			return err
 		}
 
-		err = c.writeLocalBuildActions(nw, &info.actionDefs)
+		err = c.writeLocalBuildActions(nw, &info.actionDefs, fmt.Sprintf("singleton %q", info.name))
 		if err != nil {
 			return err
 		}
@@ -3658,8 +4086,11 @@ func (c *Context) writeAllSingletonActions(nw *ninjaWriter) error {
 	return nil
 }
 
+// writeLocalBuildActions writes the local variables, rules and build statements of a module or
+// singleton.  If SetBuildLocationComments was enabled each build statement is preceded by a
+// comment containing its location and the Go function that created it.
 func (c *Context) writeLocalBuildActions(nw *ninjaWriter,
-	defs *localBuildActions) error {
+	defs *localBuildActions, location string) error {
 
 	// Write the local variable assignments.
 	for _, v := range defs.variables {
@@ -3706,6 +4137,13 @@ func (c *Context) writeLocalBuildActions(nw *ninjaWriter,
 
 	// Write the build definitions.
 	for _, buildDef := range defs.buildDefs {
+		if c.buildLocationComments {
+			err := nw.Comment(buildLocationComment(location, buildDef.Caller))
+			if err != nil {
+				return err
+			}
+		}
+
 		err := buildDef.WriteTo(nw, c.pkgNames)
 		if err != nil {
 			return err
@@ -16,6 +16,7 @@ package blueprint
 
 import (
 	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"reflect"
@@ -606,3 +607,126 @@ func TestParseFailsForModuleWithoutName(t *testing.T) {
 		t.Errorf("Incorrect errors; expected:\n%s\ngot:\n%s", expectedErrs, errs)
 	}
 }
+
+type cancelTestSingleton struct {
+	ran *bool
+}
+
+func (s cancelTestSingleton) GenerateBuildActions(SingletonContext) {
+	*s.ran = true
+}
+
+func TestCancel(t *testing.T) {
+	bp := map[string][]byte{
+		"Blueprints": []byte(`
+			foo_module {
+			    name: "A",
+			    deps: ["B"],
+			}
+
+			foo_module {
+			    name: "B",
+			}
+		`),
+	}
+
+	newCancelContext := func() (*Context, context.CancelFunc) {
+		ctx := NewContext()
+		var cancel context.CancelFunc
+		ctx.Context, cancel = context.WithCancel(context.Background())
+		ctx.RegisterModuleType("foo_module", newFooModule)
+		ctx.RegisterBottomUpMutator("deps", depsMutator)
+		ctx.MockFileSystem(bp)
+		return ctx, cancel
+	}
+
+	expectCanceled := func(t *testing.T, errs []error) {
+		t.Helper()
+		if len(errs) != 1 || errs[0] != context.Canceled {
+			t.Errorf("expected %q, got %q", context.Canceled, errs)
+		}
+	}
+
+	t.Run("parse", func(t *testing.T) {
+		ctx, cancel := newCancelContext()
+		cancel()
+
+		_, errs := ctx.ParseBlueprintsFiles("Blueprints", nil)
+		expectCanceled(t, errs)
+	})
+
+	t.Run("mutator", func(t *testing.T) {
+		ctx, cancel := newCancelContext()
+		defer cancel()
+
+		visited := 0
+		ctx.RegisterBottomUpMutator("cancel", func(BottomUpMutatorContext) {
+			visited++
+			cancel()
+		})
+		ranAfter := false
+		ctx.RegisterBottomUpMutator("after", func(BottomUpMutatorContext) {
+			ranAfter = true
+		})
+
+		_, errs := ctx.ParseBlueprintsFiles("Blueprints", nil)
+		if len(errs) > 0 {
+			t.Fatalf("unexpected errors: %q", errs)
+		}
+
+		_, errs = ctx.ResolveDependencies(nil)
+		expectCanceled(t, errs)
+		if visited != 1 {
+			t.Errorf("expected cancelled mutator to visit 1 module, visited %d", visited)
+		}
+		if ranAfter {
+			t.Errorf("expected mutators after cancellation not to run")
+		}
+	})
+
+	t.Run("generate", func(t *testing.T) {
+		ctx, cancel := newCancelContext()
+		defer cancel()
+
+		singletonRan := false
+		ctx.RegisterSingletonType("singleton", func() Singleton {
+			return cancelTestSingleton{&singletonRan}
+		})
+
+		_, errs := ctx.ParseBlueprintsFiles("Blueprints", nil)
+		if len(errs) == 0 {
+			_, errs = ctx.ResolveDependencies(nil)
+		}
+		if len(errs) > 0 {
+			t.Fatalf("unexpected errors: %q", errs)
+		}
+
+		cancel()
+
+		_, _, errs = ctx.PrepareBuildActions(nil)
+		expectCanceled(t, errs)
+		if singletonRan {
+			t.Errorf("expected singletons not to run after cancellation")
+		}
+	})
+
+	t.Run("write", func(t *testing.T) {
+		ctx, cancel := newCancelContext()
+		defer cancel()
+
+		_, errs := ctx.ParseBlueprintsFiles("Blueprints", nil)
+		if len(errs) == 0 {
+			_, _, errs = ctx.PrepareBuildActions(nil)
+		}
+		if len(errs) > 0 {
+			t.Fatalf("unexpected errors: %q", errs)
+		}
+
+		cancel()
+
+		buf := &bytes.Buffer{}
+		if err := ctx.WriteBuildFile(buf); err != context.Canceled {
+			t.Errorf("expected %q, got %q", context.Canceled, err)
+		}
+	})
+}
@@ -0,0 +1,353 @@
+// Copyright 2020 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blueprint
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/google/blueprint/proptools"
+)
+
+// Defaults modules hold property values that are shared by other modules.  A module type opts in
+// by embedding DefaultableModuleBase and calling InitDefaultableModule from its factory, which adds
+// a "defaults" property listing the defaults modules to apply:
+//
+//   my_defaults {
+//       name: "common",
+//       cflags: ["-Wall"],
+//   }
+//
+//   my_module {
+//       name: "foo",
+//       defaults: ["common"],
+//       cflags: ["-Werror"],
+//   }
+//
+// Defaults module types are registered with Context.RegisterDefaultsModuleType.  Defaults modules
+// may themselves list other defaults modules in their own defaults property.
+//
+// Defaults are applied by a mutator that the first call to RegisterDefaultsModuleType registers
+// ahead of all of the mutators registered with RegisterTopDownMutator and RegisterBottomUpMutator,
+// so that it runs after the early mutators registered with RegisterEarlyMutator but before the
+// other mutators, unless their ordering constraints say otherwise.  Contexts that don't register a
+// defaults module type don't run it.  The defaults modules
+// listed by a module, and recursively the defaults modules listed by those, are applied in order,
+// followed by the module's own properties, with later values appended to lists and replacing
+// pointer values.  Modules that are created by mutators do not have defaults applied.
+
+const defaultsProperty = "defaults"
+
+// DefaultsProperties contains the property that lists the defaults modules to apply to a module.
+type DefaultsProperties struct {
+	// Defaults is the list of defaults modules whose properties are applied to the module.
+	Defaults []string
+}
+
+// A Defaultable module has properties that can be set by defaults modules.
+type Defaultable interface {
+	Module
+
+	defaultable() *DefaultableModuleBase
+}
+
+// DefaultableModuleBase is an embeddable object that allows a module to use defaults modules.
+// Modules that embed it must call InitDefaultableModule from their factory.
+type DefaultableModuleBase struct {
+	defaultsProperties    DefaultsProperties
+	defaultableProperties []interface{}
+}
+
+func (d *DefaultableModuleBase) defaultable() *DefaultableModuleBase {
+	return d
+}
+
+// InitDefaultableModule records which property structs of module can be set by defaults modules,
+// and returns them along with the property struct for the defaults property.  It is meant to be
+// called from a ModuleFactory:
+//
+//	func newMyModule() (blueprint.Module, []interface{}) {
+//	    m := &myModule{}
+//	    return m, blueprint.InitDefaultableModule(m, &m.properties, &m.SimpleName.Properties)
+//	}
+func InitDefaultableModule(module Defaultable, properties ...interface{}) []interface{} {
+	d := module.defaultable()
+	d.defaultableProperties = properties
+	return append(properties[:len(properties):len(properties)], &d.defaultsProperties)
+}
+
+type defaultsModule struct {
+	SimpleName
+
+	defaultsProperties DefaultsProperties
+
+	// properties contains the property structs that are applied to modules that use the defaults.
+	properties []interface{}
+}
+
+func (d *defaultsModule) GenerateBuildActions(ModuleContext) {
+}
+
+// RegisterDefaultsModuleType registers a module type for defaults modules that can set any of the
+// properties of the module types created by factories.
+func (c *Context) RegisterDefaultsModuleType(name string, factories ...ModuleFactory) {
+	c.RegisterModuleType(name, defaultsModuleFactory(factories))
+
+	if !c.defaultsMutatorRegistered {
+		c.defaultsMutatorRegistered = true
+		c.RegisterBottomUpMutator("blueprint_defaults", defaultsMutator).Parallel()
+		// Move the mutator ahead of the mutators that were already registered.
+		last := len(c.mutatorInfo) - 1
+		c.mutatorInfo = append([]*mutatorInfo{c.mutatorInfo[last]}, c.mutatorInfo[:last]...)
+	}
+}
+
+func defaultsModuleFactory(factories []ModuleFactory) ModuleFactory {
+	return func() (Module, []interface{}) {
+		m := &defaultsModule{}
+
+		seen := map[reflect.Type]bool{
+			reflect.TypeOf(&m.SimpleName.Properties): true,
+			reflect.TypeOf(&m.defaultsProperties):    true,
+		}
+
+		for _, factory := range factories {
+			module, properties := factory()
+			if d, ok := module.(Defaultable); ok {
+				properties = d.defaultable().defaultableProperties
+			}
+			for _, p := range properties {
+				typ := reflect.TypeOf(p)
+				if seen[typ] {
+					continue
+				}
+				seen[typ] = true
+				empty := proptools.CloneEmptyProperties(reflect.ValueOf(p))
+				m.properties = append(m.properties, empty.Interface())
+			}
+		}
+
+		properties := []interface{}{&m.SimpleName.Properties, &m.defaultsProperties}
+		return m, append(properties, m.properties...)
+	}
+}
+
+// defaultsMutator applies defaults modules to the modules that list them, and reports cycles
+// between defaults modules.
+func defaultsMutator(ctx BottomUpMutatorContext) {
+	mctx := ctx.(*mutatorContext)
+	module := mctx.module
+
+	switch m := module.logicModule.(type) {
+	case *defaultsModule:
+		_, cycle := mctx.context.flattenDefaults(mctx, m.defaultsProperties.Defaults)
+		if len(cycle) == 0 || cycle[0] != module {
+			return
+		}
+		// Only report the cycle once, from the defaults module with the lowest name.
+		var names []string
+		for _, d := range cycle[:len(cycle)-1] {
+			if d.Name() < module.Name() {
+				return
+			}
+			names = append(names, fmt.Sprintf("%q", d.Name()))
+		}
+		names = append(names, fmt.Sprintf("%q", module.Name()))
+		ctx.PropertyErrorf(defaultsProperty, "defaults cycle: %s", strings.Join(names, " -> "))
+
+	case Defaultable:
+		d := m.defaultable()
+		if len(d.defaultsProperties.Defaults) == 0 {
+			return
+		}
+		defaults, cycle := mctx.context.flattenDefaults(mctx, d.defaultsProperties.Defaults)
+		if len(cycle) > 0 || ctx.Failed() {
+			// Cycles are reported by the defaults modules in the cycle.
+			return
+		}
+		applyDefaults(mctx, d.defaultableProperties, defaults)
+	}
+}
+
+// flattenDefaults returns the defaults modules named by names and, recursively, the defaults modules
+// they name, in the order they should be applied.  Errors are only reported for names, errors in
+// other defaults modules are reported when the mutator visits them.  If a cycle is found it is
+// returned as a list of modules that starts and ends with the same module.
+func (c *Context) flattenDefaults(mctx *mutatorContext,
+	names []string) (defaults []*moduleInfo, cycle []*moduleInfo) {
+
+	visited := make(map[*moduleInfo]bool)
+	stack := []*moduleInfo{mctx.module}
+
+	var visit func(from *moduleInfo, names []string) bool
+	visit = func(from *moduleInfo, names []string) bool {
+		for _, name := range names {
+			d, err := c.lookupDefaults(from, name)
+			if err != nil {
+				if from == mctx.module {
+					mctx.PropertyErrorf(defaultsProperty, "%s", err)
+				}
+				continue
+			}
+
+			for i, s := range stack {
+				if s == d {
+					cycle = append(append([]*moduleInfo(nil), stack[i:]...), d)
+					return false
+				}
+			}
+
+			if visited[d] {
+				continue
+			}
+			visited[d] = true
+
+			stack = append(stack, d)
+			if !visit(d, d.logicModule.(*defaultsModule).defaultsProperties.Defaults) {
+				return false
+			}
+			stack = stack[:len(stack)-1]
+
+			defaults = append(defaults, d)
+		}
+		return true
+	}
+
+	if !visit(mctx.module, names) {
+		return nil, cycle
+	}
+
+	return defaults, nil
+}
+
+func (c *Context) lookupDefaults(from *moduleInfo, name string) (*moduleInfo, error) {
+	group := c.moduleGroupFromName(name, from.namespace())
+	if group == nil || len(group.modules) == 0 {
+		return nil, fmt.Errorf("defaults module %q not found", name)
+	}
+
+	d := group.modules[0]
+	if _, ok := d.logicModule.(*defaultsModule); !ok {
+		return nil, fmt.Errorf("module %q is not a defaults module", name)
+	}
+
+	return d, nil
+}
+
+// applyDefaults prepends the properties of each of the defaults modules, starting from the last
+// one, to the matching property structs of the module, and reports any property set in a defaults
+// module that the module does not have.
+func applyDefaults(mctx *mutatorContext, properties []interface{}, defaults []*moduleInfo) {
+	// The name and defaults properties are never inherited.
+	filter := func(property string, dstField, srcField reflect.StructField,
+		dstValue, srcValue interface{}) (bool, error) {
+		return property != "name" && property != defaultsProperty, nil
+	}
+
+	for i := len(defaults) - 1; i >= 0; i-- {
+		d := defaults[i].logicModule.(*defaultsModule)
+		for _, defaultsProps := range d.properties {
+			for _, props := range properties {
+				if reflect.TypeOf(props) != reflect.TypeOf(defaultsProps) {
+					continue
+				}
+				err := proptools.PrependProperties(props, defaultsProps, filter)
+				if err != nil {
+					mctx.PropertyErrorf(defaultsProperty, "failed to apply defaults %q: %s",
+						defaults[i].Name(), err)
+				}
+			}
+		}
+	}
+
+	for _, d := range defaults {
+		reportUnmatchedDefaults(mctx, properties, d)
+	}
+}
+
+// reportUnmatchedDefaults reports an error for each property set in the defaults module d that was
+// not applied to the module because none of the module's property structs match a property struct
+// of d that contains it.
+func reportUnmatchedDefaults(mctx *mutatorContext, properties []interface{}, d *moduleInfo) {
+	var matched []reflect.Type
+	for _, defaultsProps := range d.logicModule.(*defaultsModule).properties {
+		for _, props := range properties {
+			if reflect.TypeOf(props) == reflect.TypeOf(defaultsProps) {
+				matched = append(matched, reflect.TypeOf(props))
+				break
+			}
+		}
+	}
+
+	var unmatched []string
+	for property := range d.propertyPos {
+		if strings.Contains(property, ".") || property == "name" || property == defaultsProperty {
+			continue
+		}
+		found := false
+		for _, typ := range matched {
+			if hasProperty(typ, property) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			unmatched = append(unmatched, property)
+		}
+	}
+
+	sort.Strings(unmatched)
+	for _, property := range unmatched {
+		mctx.error(&PropertyError{
+			ModuleError: ModuleError{
+				BlueprintError: BlueprintError{
+					Err: fmt.Errorf("property is not supported by module %q of type %q",
+						mctx.module.Name(), mctx.module.typeName),
+					Pos: d.propertyPos[property],
+				},
+				module: d,
+			},
+			property: property,
+		})
+	}
+}
+
+// hasProperty returns true if the struct type, or pointer to struct type, typ contains a field for
+// the top level property, including in embedded structs.
+func hasProperty(typ reflect.Type, property string) bool {
+	for typ.Kind() == reflect.Ptr {
+		typ = typ.Elem()
+	}
+	if typ.Kind() != reflect.Struct {
+		return false
+	}
+
+	fieldName := proptools.FieldNameForProperty(property)
+	for i := 0; i < typ.NumField(); i++ {
+		field := typ.Field(i)
+		if field.Anonymous || field.Name == "BlueprintEmbed" {
+			if hasProperty(field.Type, property) {
+				return true
+			}
+			continue
+		}
+		if field.PkgPath == "" && field.Name == fieldName {
+			return true
+		}
+	}
+	return false
+}
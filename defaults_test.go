@@ -0,0 +1,270 @@
+// Copyright 2020 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blueprint
+
+import (
+	"reflect"
+	"testing"
+)
+
+type defaultsTestModule struct {
+	SimpleName
+	DefaultableModuleBase
+	properties struct {
+		Cflags  []string
+		Enabled *bool
+	}
+}
+
+func newDefaultsTestModule() (Module, []interface{}) {
+	m := &defaultsTestModule{}
+	return m, InitDefaultableModule(m, &m.properties, &m.SimpleName.Properties)
+}
+
+func (m *defaultsTestModule) GenerateBuildActions(ModuleContext) {
+}
+
+type defaultsTestOtherModule struct {
+	SimpleName
+	properties struct {
+		Srcs []string
+	}
+}
+
+func newDefaultsTestOtherModule() (Module, []interface{}) {
+	m := &defaultsTestOtherModule{}
+	return m, []interface{}{&m.properties, &m.SimpleName.Properties}
+}
+
+func (m *defaultsTestOtherModule) GenerateBuildActions(ModuleContext) {
+}
+
+func TestDefaults(t *testing.T) {
+	type result struct {
+		cflags  []string
+		enabled *bool
+	}
+
+	testCases := []struct {
+		name string
+		bp   string
+		want map[string]result
+		errs []string
+	}{
+		{
+			name: "order",
+			bp: `
+				test_defaults {
+					name: "d0",
+					cflags: ["d0"],
+				}
+
+				test_defaults {
+					name: "d1",
+					defaults: ["d0"],
+					cflags: ["d1"],
+					enabled: false,
+				}
+
+				test_defaults {
+					name: "d2",
+					defaults: ["d0"],
+					cflags: ["d2"],
+					enabled: true,
+				}
+
+				test {
+					name: "a",
+					defaults: ["d1", "d2"],
+					cflags: ["a"],
+				}
+
+				test {
+					name: "b",
+					defaults: ["d2"],
+					enabled: false,
+				}
+
+				test {
+					name: "c",
+				}
+			`,
+			want: map[string]result{
+				"a": {[]string{"d0", "d1", "d2", "a"}, boolPtr(true)},
+				"b": {[]string{"d0", "d2"}, boolPtr(false)},
+				"c": {nil, nil},
+			},
+		},
+		{
+			name: "cycle",
+			bp: `
+				test_defaults {
+					name: "d1",
+					defaults: ["d2"],
+				}
+
+				test_defaults {
+					name: "d2",
+					defaults: ["d1"],
+				}
+
+				test {
+					name: "a",
+					defaults: ["d2"],
+				}
+			`,
+			errs: []string{
+				`Blueprints:4:14: module "d1": defaults: defaults cycle: "d1" -> "d2" -> "d1"`,
+			},
+		},
+		{
+			name: "missing",
+			bp: `
+				test {
+					name: "a",
+					defaults: ["d1", "b"],
+				}
+
+				other_test {
+					name: "b",
+				}
+			`,
+			errs: []string{
+				`Blueprints:4:14: module "a": defaults: defaults module "d1" not found`,
+				`Blueprints:4:14: module "a": defaults: module "b" is not a defaults module`,
+			},
+		},
+		{
+			name: "unmatched",
+			bp: `
+				test_defaults {
+					name: "d1",
+					cflags: ["d1"],
+					srcs: ["d1.c"],
+				}
+
+				test {
+					name: "a",
+					defaults: ["d1"],
+				}
+			`,
+			errs: []string{
+				`Blueprints:5:10: module "d1": srcs: property is not supported by module "a" of type "test"`,
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := NewContext()
+			ctx.RegisterModuleType("test", newDefaultsTestModule)
+			ctx.RegisterModuleType("other_test", newDefaultsTestOtherModule)
+			ctx.RegisterDefaultsModuleType("test_defaults", newDefaultsTestModule,
+				newDefaultsTestOtherModule)
+			ctx.MockFileSystem(map[string][]byte{
+				"Blueprints": []byte(tc.bp),
+			})
+
+			_, errs := ctx.ParseBlueprintsFiles("Blueprints", nil)
+			if len(errs) > 0 {
+				t.Errorf("unexpected parse errors:")
+				for _, err := range errs {
+					t.Errorf("  %s", err)
+				}
+				t.FailNow()
+			}
+
+			_, errs = ctx.ResolveDependencies(nil)
+			expectedErrors(t, errs, tc.errs...)
+
+			for name, want := range tc.want {
+				m := ctx.moduleGroupFromName(name, nil).modules[0].logicModule.(*defaultsTestModule)
+				got := result{m.properties.Cflags, m.properties.Enabled}
+				if !reflect.DeepEqual(got, want) {
+					t.Errorf("module %s: expected cflags %q enabled %v, got cflags %q enabled %v",
+						name, want.cflags, fmtBoolPtr(want.enabled), got.cflags, fmtBoolPtr(got.enabled))
+				}
+			}
+		})
+	}
+}
+
+func boolPtr(b bool) *bool {
+	return &b
+}
+
+func fmtBoolPtr(b *bool) interface{} {
+	if b == nil {
+		return nil
+	}
+	return *b
+}
+
+func TestDefaultsMutatorOrder(t *testing.T) {
+	mutatorNames := func(ctx *Context) []string {
+		var names []string
+		for _, m := range ctx.mutatorInfo {
+			names = append(names, m.name)
+		}
+		return names
+	}
+
+	ctx := NewContext()
+	ctx.RegisterModuleType("test", newDefaultsTestModule)
+	want := []string{"blueprint_deps", "blueprint_alias_deps"}
+	if got := mutatorNames(ctx); !reflect.DeepEqual(got, want) {
+		t.Errorf("expected mutators %q without a defaults module type, got %q", want, got)
+	}
+
+	// The defaults mutator runs before mutators registered before the defaults module type.
+	var cflags []string
+	ctx.RegisterBottomUpMutator("record", func(ctx BottomUpMutatorContext) {
+		if m, ok := ctx.Module().(*defaultsTestModule); ok {
+			cflags = m.properties.Cflags
+		}
+	})
+	ctx.RegisterDefaultsModuleType("test_defaults", newDefaultsTestModule)
+	ctx.RegisterDefaultsModuleType("other_test_defaults", newDefaultsTestModule)
+
+	want = []string{"blueprint_defaults", "blueprint_deps", "blueprint_alias_deps", "record"}
+	if got := mutatorNames(ctx); !reflect.DeepEqual(got, want) {
+		t.Errorf("expected mutators %q, got %q", want, got)
+	}
+
+	ctx.MockFileSystem(map[string][]byte{
+		"Blueprints": []byte(`
+			test_defaults {
+				name: "d",
+				cflags: ["d"],
+			}
+
+			test {
+				name: "a",
+				defaults: ["d"],
+				cflags: ["a"],
+			}
+		`),
+	})
+
+	_, errs := ctx.ParseBlueprintsFiles("Blueprints", nil)
+	if len(errs) == 0 {
+		_, errs = ctx.ResolveDependencies(nil)
+	}
+	expectedErrors(t, errs)
+
+	if want := []string{"d", "a"}; !reflect.DeepEqual(cflags, want) {
+		t.Errorf("expected mutator to see cflags %q, got %q", want, cflags)
+	}
+}
@@ -0,0 +1,69 @@
+// Copyright 2020 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blueprint
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"hash"
+	"io"
+)
+
+// SetHashBlueprintsFiles enables or disables recording a hash of the contents of each Blueprints
+// file as it is parsed, which are returned by BlueprintsFileHashes.  It must be called before
+// ParseBlueprintsFiles or ParseFileList.
+func (c *Context) SetHashBlueprintsFiles(hash bool) {
+	c.hashBlueprintsFiles = hash
+}
+
+// BlueprintsFileHashes returns a map from the path of each Blueprints file that was parsed, as
+// returned in the dependencies of ParseBlueprintsFiles and ParseFileList, to the hex encoded SHA-1
+// hash of the contents it was parsed from.  It returns nil unless SetHashBlueprintsFiles was
+// enabled.
+func (c *Context) BlueprintsFileHashes() map[string]string {
+	c.blueprintsHashesLock.Lock()
+	defer c.blueprintsHashesLock.Unlock()
+
+	if c.blueprintsHashes == nil {
+		return nil
+	}
+	ret := make(map[string]string, len(c.blueprintsHashes))
+	for file, hash := range c.blueprintsHashes {
+		ret[file] = hash
+	}
+	return ret
+}
+
+// hashBlueprintsReader returns a reader that hashes the contents of the Blueprints file read from
+// r, and a function that records the hash once the file has been parsed.  If hashing is disabled
+// it returns r and a function that does nothing.
+func (c *Context) hashBlueprintsReader(filename string, r io.Reader) (io.Reader, func()) {
+	if !c.hashBlueprintsFiles {
+		return r, func() {}
+	}
+
+	h := sha1.New()
+	return io.TeeReader(r, h), func() { c.recordBlueprintsHash(filename, h) }
+}
+
+func (c *Context) recordBlueprintsHash(filename string, h hash.Hash) {
+	c.blueprintsHashesLock.Lock()
+	defer c.blueprintsHashesLock.Unlock()
+
+	if c.blueprintsHashes == nil {
+		c.blueprintsHashes = make(map[string]string)
+	}
+	c.blueprintsHashes[filename] = hex.EncodeToString(h.Sum(nil))
+}
@@ -0,0 +1,70 @@
+// Copyright 2020 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blueprint
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestBlueprintsFileHashes(t *testing.T) {
+	files := map[string][]byte{
+		"Blueprints":   []byte(`test { name: "root" }`),
+		"a/Blueprints": []byte(`test { name: "a" }`),
+	}
+
+	parse := func(hash bool) (*Context, []string) {
+		t.Helper()
+		ctx := NewContext()
+		ctx.RegisterModuleType("test", newShardsModule)
+		ctx.SetHashBlueprintsFiles(hash)
+		ctx.MockFileSystem(files)
+		deps, errs := ctx.ParseBlueprintsFiles("Blueprints", nil)
+		if len(errs) > 0 {
+			t.Fatalf("unexpected errors: %q", errs)
+		}
+		return ctx, deps
+	}
+
+	// MockFileSystem adds the module list file to files, so the hashes are computed first.
+	want := make(map[string]string)
+	for file, contents := range files {
+		h := sha1.Sum(contents)
+		want[file] = hex.EncodeToString(h[:])
+	}
+
+	ctx, deps := parse(true)
+	if got := ctx.BlueprintsFileHashes(); !reflect.DeepEqual(got, want) {
+		t.Errorf("incorrect hashes:\nwant:\n  %q\ngot:\n  %q", want, got)
+	}
+
+	// The hashes are keyed by the paths returned as dependencies.
+	var hashed []string
+	for file := range want {
+		hashed = append(hashed, file)
+	}
+	sort.Strings(hashed)
+	sort.Strings(deps)
+	if !reflect.DeepEqual(deps, hashed) {
+		t.Errorf("expected hashes for the dependencies %q, got %q", deps, hashed)
+	}
+
+	if ctx, _ := parse(false); ctx.BlueprintsFileHashes() != nil {
+		t.Errorf("expected no hashes when hashing is disabled, got %q", ctx.BlueprintsFileHashes())
+	}
+}
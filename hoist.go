@@ -0,0 +1,128 @@
+// Copyright 2020 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blueprint
+
+import (
+	"sort"
+	"strconv"
+)
+
+// SetHoistBuildArgs enables an optimization at the end of PrepareBuildActions that moves each value
+// of a rule argument that is set by at least minStatements build statements using the same rule
+// into a generated global variable, so that the value is written to the Ninja file once instead of
+// once per build statement.  Only values that refer to global variables and no other variables are
+// moved, so the evaluated commands of the build statements are unchanged.  A minStatements of 0,
+// the default, disables the optimization.
+func (c *Context) SetHoistBuildArgs(minStatements int) {
+	c.hoistBuildArgsMin = minStatements
+}
+
+// hoistBuildArgs moves the values of rule arguments that are shared by at least
+// c.hoistBuildArgsMin build statements into generated global variables named
+// h.<rule>.<argument>.<index>.
+func (c *Context) hoistBuildArgs() {
+	type argValue struct {
+		rule  string
+		arg   Variable
+		value string
+	}
+
+	type hoistCandidate struct {
+		argValue
+		ninjaValue ninjaString
+		defs       []*buildDef
+	}
+
+	candidates := make(map[argValue]*hoistCandidate)
+	var order []*hoistCandidate
+
+	visit := func(actions *localBuildActions) {
+		for _, def := range actions.buildDefs {
+			if len(def.Args) == 0 {
+				continue
+			}
+
+			// Visit the arguments in a consistent order so that the generated variables are
+			// deterministic.
+			args := make([]Variable, 0, len(def.Args))
+			for arg := range def.Args {
+				args = append(args, arg)
+			}
+			sort.Slice(args, func(i, j int) bool { return args[i].name() < args[j].name() })
+
+			rule := def.Rule.fullName(c.pkgNames)
+			for _, arg := range args {
+				value := def.Args[arg]
+				if !c.onlyReferencesGlobals(value) {
+					continue
+				}
+
+				key := argValue{rule, arg, value.Value(c.pkgNames)}
+				candidate := candidates[key]
+				if candidate == nil {
+					candidate = &hoistCandidate{argValue: key, ninjaValue: value}
+					candidates[key] = candidate
+					order = append(order, candidate)
+				}
+				candidate.defs = append(candidate.defs, def)
+			}
+		}
+	}
+
+	for _, module := range c.modulesSortedByName() {
+		visit(&module.actionDefs)
+	}
+	for _, info := range c.singletonInfo {
+		visit(&info.actionDefs)
+	}
+
+	indexes := make(map[string]int)
+	for _, candidate := range order {
+		if len(candidate.defs) < c.hoistBuildArgsMin {
+			continue
+		}
+
+		prefix := candidate.rule + "." + candidate.arg.name()
+		v := &localVariable{
+			namePrefix: "h.",
+			name_:      prefix + "." + strconv.Itoa(indexes[prefix]),
+			value_:     candidate.ninjaValue,
+		}
+
+		// Hoisting a value that is shorter than the reference to the variable would make the
+		// Ninja file larger.
+		if len(candidate.value) <= len("${}")+len(v.fullName(c.pkgNames)) {
+			continue
+		}
+		indexes[prefix]++
+
+		c.globalVariables[v] = candidate.ninjaValue
+		ref := variableNinjaString(v, c.pkgNames)
+		for _, def := range candidate.defs {
+			def.Args[candidate.arg] = ref
+		}
+	}
+}
+
+// onlyReferencesGlobals returns true if every variable referenced by str is a global variable, so
+// that str has the same value when it is assigned to a global variable.
+func (c *Context) onlyReferencesGlobals(str ninjaString) bool {
+	for _, v := range str.Variables() {
+		if _, ok := c.globalVariables[v]; !ok {
+			return false
+		}
+	}
+	return true
+}
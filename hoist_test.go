@@ -0,0 +1,152 @@
+// Copyright 2020 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blueprint
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+var (
+	hoistPctx = NewPackageContext("github.com/google/blueprint/hoist_test")
+
+	hoistIncludeDir = hoistPctx.StaticVariable("includeDir", "include")
+
+	hoistCompile = hoistPctx.StaticRule("cc", RuleParams{
+		Command: "cc $cflags -I$includes -c $in -o $out",
+	}, "cflags", "includes")
+)
+
+type hoistModule struct {
+	SimpleName
+	properties struct {
+		Cflags       string
+		Local_cflags bool
+	}
+}
+
+func newHoistModule() (Module, []interface{}) {
+	m := &hoistModule{}
+	return m, []interface{}{&m.properties, &m.SimpleName.Properties}
+}
+
+func (m *hoistModule) GenerateBuildActions(ctx ModuleContext) {
+	cflags := m.properties.Cflags
+	if m.properties.Local_cflags {
+		ctx.Variable(hoistPctx, "localCflags", cflags)
+		cflags = "${localCflags}"
+	}
+
+	for _, src := range []string{"a", "b"} {
+		ctx.Build(hoistPctx, BuildParams{
+			Rule:    hoistCompile,
+			Outputs: []string{ctx.ModuleName() + "/" + src + ".o"},
+			Inputs:  []string{src + ".c"},
+			Args: map[string]string{
+				"cflags":   cflags,
+				"includes": "${includeDir}/" + ctx.ModuleName(),
+			},
+		})
+	}
+}
+
+func runHoistTest(t *testing.T, minStatements int) (*Context, string) {
+	t.Helper()
+
+	ctx := NewContext()
+	ctx.RegisterModuleType("test", newHoistModule)
+	ctx.SetHoistBuildArgs(minStatements)
+	ctx.MockFileSystem(map[string][]byte{
+		"Blueprints": []byte(`
+			test {
+				name: "x",
+				cflags: "-O2 -Wall -Werror -Wextra -fno-exceptions -DNDEBUG",
+			}
+
+			test {
+				name: "y",
+				cflags: "-O2 -Wall -Werror -Wextra -fno-exceptions -DNDEBUG",
+			}
+
+			test {
+				name: "z",
+				cflags: "-O2 -Wall -Werror -Wextra -fno-exceptions -DNDEBUG",
+				local_cflags: true,
+			}
+		`),
+	})
+
+	_, errs := ctx.ParseBlueprintsFiles("Blueprints", nil)
+	if len(errs) == 0 {
+		_, errs = ctx.ResolveDependencies(nil)
+	}
+	if len(errs) == 0 {
+		_, _, errs = ctx.PrepareBuildActions(nil)
+	}
+	if len(errs) > 0 {
+		t.Fatalf("unexpected errors: %q", errs)
+	}
+
+	buf := &bytes.Buffer{}
+	if err := ctx.WriteBuildFile(buf); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	return ctx, buf.String()
+}
+
+func hoistTestStatements(t *testing.T, ctx *Context) map[string][]BuildStatement {
+	t.Helper()
+	statements := make(map[string][]BuildStatement)
+	ctx.VisitAllModules(func(m Module) {
+		s, err := ctx.ModuleBuildStatements(m)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		statements[ctx.ModuleName(m)] = s
+	})
+	return statements
+}
+
+func TestHoistBuildArgs(t *testing.T) {
+	unhoistedCtx, unhoisted := runHoistTest(t, 0)
+	hoistedCtx, hoisted := runHoistTest(t, 3)
+
+	if strings.Contains(unhoisted, "h.g.hoist_test") {
+		t.Errorf("expected no hoisted variables without SetHoistBuildArgs, got:\n%s", unhoisted)
+	}
+
+	for _, want := range []string{
+		// The cflags of x and y are shared by four build statements.
+		"h.g.hoist_test.cc.cflags.0 = -O2 -Wall -Werror -Wextra -fno-exceptions -DNDEBUG\n",
+		"build x/a.o: g.hoist_test.cc a.c\n    cflags = ${h.g.hoist_test.cc.cflags.0}\n",
+		"build y/b.o: g.hoist_test.cc b.c\n    cflags = ${h.g.hoist_test.cc.cflags.0}\n",
+		// The cflags of z refer to a module variable, so they can't be hoisted.
+		"build z/a.o: g.hoist_test.cc a.c\n    cflags = ${m.z_.localCflags}\n",
+		// The includes are only shared by the two build statements of each module.
+		"    includes = ${g.hoist_test.includeDir}/x\n",
+	} {
+		if !strings.Contains(hoisted, want) {
+			t.Errorf("expected build file to contain %q, got:\n%s", want, hoisted)
+		}
+	}
+
+	got, want := hoistTestStatements(t, hoistedCtx), hoistTestStatements(t, unhoistedCtx)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("hoisting changed the build statements, expected:\n%#v\ngot:\n%#v", want, got)
+	}
+}
@@ -158,6 +158,14 @@ type EarlyModuleContext interface {
 	// PropertyErrorf reports an error at the line number of a property in the module definition.
 	PropertyErrorf(property, fmt string, args ...interface{})
 
+	// ModuleWarningf reports a warning in category at the line number of the module type in the
+	// module definition.  Warnings do not cause the build to fail, see Context.Warnings.
+	ModuleWarningf(category, fmt string, args ...interface{})
+
+	// PropertyWarningf reports a warning in category at the line number of a property in the module
+	// definition.  Warnings do not cause the build to fail, see Context.Warnings.
+	PropertyWarningf(property, category, fmt string, args ...interface{})
+
 	// Failed returns true if any errors have been reported.  In most cases the module can continue with generating
 	// build rules after an error, allowing it to report additional errors in a single run, but in cases where the error
 	// has prevented the module from creating necessary data it can return early when Failed returns true.
@@ -340,6 +348,7 @@ type baseModuleContext struct {
 	config         interface{}
 	module         *moduleInfo
 	errs           []error
+	warnings       []*Warning
 	visitingParent *moduleInfo
 	visitingDep    depInfo
 	ninjaFileDeps  []string
@@ -426,6 +435,16 @@ func (d *baseModuleContext) PropertyErrorf(property, format string,
 	})
 }
 
+func (d *baseModuleContext) ModuleWarningf(category, format string, args ...interface{}) {
+	d.warnings = append(d.warnings, newModuleWarning(d.module, category, format, args))
+}
+
+func (d *baseModuleContext) PropertyWarningf(property, category, format string,
+	args ...interface{}) {
+
+	d.warnings = append(d.warnings, newPropertyWarning(d.module, property, category, format, args))
+}
+
 func (d *baseModuleContext) Failed() bool {
 	return len(d.errs) > 0
 }
@@ -692,6 +711,10 @@ func (m *moduleContext) Build(pctx PackageContext, params BuildParams) {
 		panic(err)
 	}
 
+	if m.context.buildLocationComments {
+		def.Caller = buildCaller()
+	}
+
 	m.actionDefs.buildDefs = append(m.actionDefs.buildDefs, def)
 }
 
@@ -885,16 +908,20 @@ func (mctx *mutatorContext) MutatorName() string {
 }
 
 func (mctx *mutatorContext) CreateVariations(variationNames ...string) []Module {
-	return mctx.createVariations(variationNames, false)
+	depChooser := chooseDepInherit(mctx.name, mctx.defaultVariation)
+	return mctx.createVariations(variationNames, depChooser, false)
 }
 
 func (mctx *mutatorContext) CreateLocalVariations(variationNames ...string) []Module {
-	return mctx.createVariations(variationNames, true)
+	depChooser := chooseDepInherit(mctx.name, mctx.defaultVariation)
+	return mctx.createVariations(variationNames, depChooser, true)
 }
 
-func (mctx *mutatorContext) createVariations(variationNames []string, local bool) []Module {
+func (mctx *mutatorContext) createVariations(variationNames []string, depChooser depChooser,
+	local bool) []Module {
+
 	ret := []Module{}
-	modules, errs := mctx.context.createVariations(mctx.module, mctx.name, mctx.defaultVariation, variationNames)
+	modules, errs := mctx.context.createVariations(mctx.module, mctx.name, depChooser, variationNames)
 	if len(errs) > 0 {
 		mctx.errs = append(mctx.errs, errs...)
 	}
@@ -941,7 +968,8 @@ func (mctx *mutatorContext) AliasVariation(variationName string) {
 }
 
 func (mctx *mutatorContext) SetDependencyVariation(variationName string) {
-	mctx.context.convertDepsToVariation(mctx.module, mctx.name, variationName, nil)
+	mctx.context.convertDepsToVariation(mctx.module,
+		chooseDepExplicit(mctx.name, variationName, nil))
 }
 
 func (mctx *mutatorContext) SetDefaultDependencyVariation(variationName *string) {
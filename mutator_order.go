@@ -0,0 +1,222 @@
+// Copyright 2020 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blueprint
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+)
+
+// mutatorOrderEdge records that the mutator at index to in Context.mutatorInfo must run after the
+// mutator at index from, and the constraint that requires it.
+type mutatorOrderEdge struct {
+	from, to   int
+	constraint string
+}
+
+// sortedMutators returns the mutators registered with RegisterTopDownMutator and
+// RegisterBottomUpMutator in the order they will be run.  Mutators run in registration order,
+// except that a mutator is delayed until all of the mutators it must run after according to the
+// constraints declared with MutatorHandle.After and MutatorHandle.Before have run.  Mutators that
+// were registered as a unit, like the mutators of a TransitionMutator, are sorted as a single
+// mutator so that no other mutator can run between them.
+func (c *Context) sortedMutators() ([]*mutatorInfo, []error) {
+	mutators := c.mutatorInfo
+
+	byName := make(map[string][]int)
+	index := make(map[*mutatorInfo]int)
+	for i, m := range mutators {
+		byName[m.name] = append(byName[m.name], i)
+		index[m] = i
+	}
+
+	// unit maps each mutator to the first mutator of its unit, and members lists the mutators of
+	// each unit in registration order.  Only the first mutator of a unit takes part in the sort.
+	unit := make([]int, len(mutators))
+	members := make([][]int, len(mutators))
+	for i, m := range mutators {
+		unit[i] = i
+		if m.unit != nil {
+			unit[i] = index[m.unit]
+		}
+		members[unit[i]] = append(members[unit[i]], i)
+	}
+
+	var errs []error
+	edges := make([][]mutatorOrderEdge, len(mutators))
+	incoming := make([][]mutatorOrderEdge, len(mutators))
+	waiting := make([]int, len(mutators))
+
+	addEdge := func(from, to int, constraint string) {
+		if unit[from] == unit[to] {
+			if from > to {
+				errs = append(errs, fmt.Errorf("conflicting mutator ordering constraints:\n"+
+					"    %q before %q: %s\n"+
+					"    %q before %q: registered as a unit",
+					mutators[from].name, mutators[to].name, constraint,
+					mutators[to].name, mutators[from].name))
+			}
+			return
+		}
+		edge := mutatorOrderEdge{from, to, constraint}
+		edges[unit[from]] = append(edges[unit[from]], edge)
+		incoming[unit[to]] = append(incoming[unit[to]], edge)
+		waiting[unit[to]]++
+	}
+
+	for i, m := range mutators {
+		for _, name := range m.after {
+			others, ok := byName[name]
+			if !ok {
+				errs = append(errs, fmt.Errorf("mutator %q must run after unknown mutator %q",
+					m.name, name))
+			}
+			for _, other := range others {
+				addEdge(other, i, fmt.Sprintf("%q.After(%q)", m.name, name))
+			}
+		}
+		for _, name := range m.before {
+			others, ok := byName[name]
+			if !ok {
+				errs = append(errs, fmt.Errorf("mutator %q must run before unknown mutator %q",
+					m.name, name))
+			}
+			for _, other := range others {
+				addEdge(i, other, fmt.Sprintf("%q.Before(%q)", m.name, name))
+			}
+		}
+	}
+
+	if len(errs) > 0 {
+		return nil, errs
+	}
+
+	done := make([]bool, len(mutators))
+	sorted := make([]*mutatorInfo, 0, len(mutators))
+	for len(sorted) < len(mutators) {
+		// Pick the earliest registered mutator that is not waiting for any other mutator so that
+		// mutators without constraints keep their registration order.
+		next := -1
+		for i := range mutators {
+			if unit[i] == i && !done[i] && waiting[i] == 0 {
+				next = i
+				break
+			}
+		}
+
+		if next == -1 {
+			return nil, []error{mutatorOrderCycleError(mutators, unit, done, incoming)}
+		}
+
+		done[next] = true
+		for _, member := range members[next] {
+			sorted = append(sorted, mutators[member])
+		}
+		for _, edge := range edges[next] {
+			waiting[unit[edge.to]]--
+		}
+	}
+
+	return sorted, nil
+}
+
+// mutatorOrderCycleError returns an error describing a cycle in the constraints between the
+// mutators that could not be sorted.  Each of them must be waiting for another one of them.
+func mutatorOrderCycleError(mutators []*mutatorInfo, unit []int, done []bool,
+	incoming [][]mutatorOrderEdge) error {
+
+	start := 0
+	for unit[start] != start || done[start] {
+		start++
+	}
+
+	// Walk backwards along constraints until a unit is reached a second time.
+	seen := make(map[int]int)
+	var path []mutatorOrderEdge
+	for i := start; ; {
+		if pos, ok := seen[i]; ok {
+			path = path[pos:]
+			break
+		}
+		seen[i] = len(path)
+		for _, edge := range incoming[i] {
+			if !done[unit[edge.from]] {
+				path = append(path, edge)
+				i = unit[edge.from]
+				break
+			}
+		}
+	}
+
+	var lines []string
+	for i := len(path) - 1; i >= 0; i-- {
+		edge := path[i]
+		lines = append(lines, fmt.Sprintf("    %q before %q: %s",
+			mutators[edge.from].name, mutators[edge.to].name, edge.constraint))
+	}
+
+	return fmt.Errorf("conflicting mutator ordering constraints:\n%s", strings.Join(lines, "\n"))
+}
+
+// WriteMutatorOrder writes the order that ResolveDependencies will run the registered mutators in
+// to w, one mutator per line along with its ordering constraints, for debugging.  It returns any
+// errors in the ordering constraints.
+func (c *Context) WriteMutatorOrder(w io.Writer) []error {
+	sorted, errs := c.sortedMutators()
+	if len(errs) > 0 {
+		return errs
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+
+	write := func(i int, mutator *mutatorInfo, kind string) {
+		if mutator.parallel {
+			kind += " parallel"
+		}
+		var constraints []string
+		if mutator.unit != nil {
+			constraints = append(constraints, "runs with: "+mutator.unit.name)
+		}
+		if len(mutator.after) > 0 {
+			constraints = append(constraints, "after: "+strings.Join(mutator.after, ", "))
+		}
+		if len(mutator.before) > 0 {
+			constraints = append(constraints, "before: "+strings.Join(mutator.before, ", "))
+		}
+		fmt.Fprintf(tw, "%d\t%s\t%s", i+1, mutator.name, kind)
+		if len(constraints) > 0 {
+			fmt.Fprintf(tw, "\t%s", strings.Join(constraints, "; "))
+		}
+		fmt.Fprintln(tw)
+	}
+
+	for i, mutator := range c.earlyMutatorInfo {
+		write(i, mutator, "early")
+	}
+	for i, mutator := range sorted {
+		kind := "bottom-up"
+		if mutator.topDownMutator != nil {
+			kind = "top-down"
+		}
+		write(len(c.earlyMutatorInfo)+i, mutator, kind)
+	}
+
+	if err := tw.Flush(); err != nil {
+		return []error{err}
+	}
+	return nil
+}
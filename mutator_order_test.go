@@ -0,0 +1,193 @@
+// Copyright 2020 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blueprint
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestMutatorOrder(t *testing.T) {
+	noop := func(BottomUpMutatorContext) {}
+	noopTopDown := func(TopDownMutatorContext) {}
+
+	testCases := []struct {
+		name     string
+		register func(ctx *Context)
+		order    []string
+		errs     []string
+	}{
+		{
+			name: "registration order",
+			register: func(ctx *Context) {
+				ctx.RegisterBottomUpMutator("a", noop)
+				ctx.RegisterTopDownMutator("b", noopTopDown)
+				ctx.RegisterBottomUpMutator("c", noop)
+			},
+			order: []string{"a", "b", "c"},
+		},
+		{
+			name: "after",
+			register: func(ctx *Context) {
+				ctx.RegisterBottomUpMutator("a", noop).After("c")
+				ctx.RegisterTopDownMutator("b", noopTopDown)
+				ctx.RegisterBottomUpMutator("c", noop).Parallel()
+				ctx.RegisterBottomUpMutator("d", noop)
+			},
+			order: []string{"b", "c", "a", "d"},
+		},
+		{
+			name: "before",
+			register: func(ctx *Context) {
+				ctx.RegisterBottomUpMutator("a", noop)
+				ctx.RegisterTopDownMutator("b", noopTopDown)
+				ctx.RegisterBottomUpMutator("c", noop).Before("a", "b")
+				ctx.RegisterBottomUpMutator("d", noop).After("c").Before("b")
+			},
+			order: []string{"c", "a", "d", "b"},
+		},
+		{
+			name: "unknown",
+			register: func(ctx *Context) {
+				ctx.RegisterBottomUpMutator("a", noop).After("x")
+				ctx.RegisterBottomUpMutator("b", noop).Before("y")
+			},
+			errs: []string{
+				`mutator "a" must run after unknown mutator "x"`,
+				`mutator "b" must run before unknown mutator "y"`,
+			},
+		},
+		{
+			name: "conflict",
+			register: func(ctx *Context) {
+				ctx.RegisterBottomUpMutator("a", noop).After("c")
+				ctx.RegisterBottomUpMutator("b", noop).After("a")
+				ctx.RegisterBottomUpMutator("c", noop).After("b")
+				ctx.RegisterBottomUpMutator("d", noop)
+			},
+			errs: []string{
+				"conflicting mutator ordering constraints:\n" +
+					`    "a" before "b": "b".After("a")` + "\n" +
+					`    "b" before "c": "c".After("b")` + "\n" +
+					`    "c" before "a": "a".After("c")`,
+			},
+		},
+		{
+			name: "transition before",
+			register: func(ctx *Context) {
+				ctx.RegisterBottomUpMutator("a", noop)
+				ctx.RegisterTransitionMutator("t", transitionTestMutator{})
+				ctx.RegisterBottomUpMutator("b", noop).Before("t")
+			},
+			// b must run before t, so it runs before the whole transition.
+			order: []string{"a", "b", "t_propagate", "t", "t_mutate"},
+		},
+		{
+			name: "transition after",
+			register: func(ctx *Context) {
+				ctx.RegisterBottomUpMutator("a", noop).After("t_propagate")
+				ctx.RegisterTransitionMutator("t", transitionTestMutator{})
+				ctx.RegisterBottomUpMutator("b", noop)
+			},
+			// a must run after t_propagate, so it runs after the whole transition.
+			order: []string{"t_propagate", "t", "t_mutate", "a", "b"},
+		},
+		{
+			name: "transition split",
+			register: func(ctx *Context) {
+				ctx.RegisterTransitionMutator("t", transitionTestMutator{})
+				ctx.RegisterBottomUpMutator("a", noop).After("t_propagate").Before("t")
+			},
+			errs: []string{
+				"conflicting mutator ordering constraints:\n" +
+					`    "t_propagate" before "a": "a".After("t_propagate")` + "\n" +
+					`    "a" before "t": "a".Before("t")`,
+			},
+		},
+		{
+			name: "transition reordered",
+			register: func(ctx *Context) {
+				ctx.RegisterTransitionMutator("t", transitionTestMutator{})
+				ctx.RegisterBottomUpMutator("a", noop)
+				ctx.mutatorInfo[2].Before("t")
+			},
+			errs: []string{
+				"conflicting mutator ordering constraints:\n" +
+					`    "t_mutate" before "t": "t_mutate".Before("t")` + "\n" +
+					`    "t" before "t_mutate": registered as a unit`,
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := newContext()
+			tc.register(ctx)
+
+			sorted, errs := ctx.sortedMutators()
+			expectedErrors(t, errs, tc.errs...)
+
+			var order []string
+			for _, m := range sorted {
+				order = append(order, m.name)
+			}
+			if !reflect.DeepEqual(order, tc.order) {
+				t.Errorf("expected order %q, got %q", tc.order, order)
+			}
+		})
+	}
+}
+
+func TestMutatorOrderRun(t *testing.T) {
+	var order []string
+	record := func(name string) BottomUpMutator {
+		return func(ctx BottomUpMutatorContext) {
+			if ctx.ModuleName() == "A" {
+				order = append(order, name)
+			}
+		}
+	}
+
+	ctx := newContext()
+	ctx.RegisterModuleType("foo_module", newFooModule)
+	ctx.RegisterBottomUpMutator("a", record("a")).After("b")
+	ctx.RegisterBottomUpMutator("b", record("b"))
+	ctx.MockFileSystem(map[string][]byte{
+		"Blueprints": []byte(`
+			foo_module {
+				name: "A",
+			}
+		`),
+	})
+
+	_, errs := ctx.ParseBlueprintsFiles("Blueprints", nil)
+	if len(errs) == 0 {
+		_, errs = ctx.ResolveDependencies(nil)
+	}
+	expectedErrors(t, errs)
+
+	if expected := []string{"b", "a"}; !reflect.DeepEqual(order, expected) {
+		t.Errorf("expected mutators to run in order %q, got %q", expected, order)
+	}
+
+	buf := &bytes.Buffer{}
+	expectedErrors(t, ctx.WriteMutatorOrder(buf))
+	expected := "1  b  bottom-up\n" +
+		"2  a  bottom-up  after: b\n"
+	if buf.String() != expected {
+		t.Errorf("expected mutator order:\n%s\ngot:\n%s", expected, buf.String())
+	}
+}
@@ -0,0 +1,163 @@
+// Copyright 2020 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blueprint
+
+import (
+	"fmt"
+	"reflect"
+
+	"github.com/google/blueprint/proptools"
+)
+
+// A parallel mutator may only modify the properties of the module it is visiting, as any other
+// module may be visited concurrently.  The mutator sandbox checks this by running the mutator on
+// one module at a time, snapshotting the property structs of every module before the mutator runs
+// and comparing every other module with its snapshot after each visit, so that a modification can
+// be attributed to the visit that made it.  After a module is visited its properties, or those of
+// the variants it was split into, are snapshotted again.
+type mutatorSandbox struct {
+	mutator   string
+	modules   []*moduleInfo
+	snapshots map[*moduleInfo][]interface{}
+}
+
+// SetCheckParallelMutators enables or disables checking that mutators registered with Parallel
+// only modify the properties of the module they are visiting.  Modifications to the properties of
+// other modules are reported as errors from ResolveDependencies that name the mutator, the module
+// that was being visited and the module and property that were modified.  Checking runs parallel
+// mutators on one module at a time and compares the properties of every module after each visit,
+// so it is slow and should only be enabled to debug mutators.
+func (c *Context) SetCheckParallelMutators(check bool) {
+	c.checkParallelMutators = check
+}
+
+func newMutatorSandbox(mutator string, modules []*moduleInfo) *mutatorSandbox {
+	s := &mutatorSandbox{
+		mutator:   mutator,
+		modules:   modules,
+		snapshots: make(map[*moduleInfo][]interface{}, len(modules)),
+	}
+	for _, module := range modules {
+		s.snapshots[module] = snapshotProperties(module)
+	}
+	return s
+}
+
+func snapshotProperties(module *moduleInfo) []interface{} {
+	snapshot := make([]interface{}, len(module.properties))
+	for i, p := range module.properties {
+		snapshot[i] = proptools.CloneProperties(reflect.ValueOf(p)).Interface()
+	}
+	return snapshot
+}
+
+// afterVisit returns an error for each other module whose properties were modified while the
+// mutator visited module, and snapshots the properties of module, or of the variants it was split
+// into.  It does nothing if s is nil.
+func (s *mutatorSandbox) afterVisit(module *moduleInfo) (errs []error) {
+	if s == nil {
+		return nil
+	}
+
+	for _, m := range s.modules {
+		if m == module {
+			continue
+		}
+		for _, other := range visitedModules(m) {
+			snapshot, ok := s.snapshots[other]
+			if !ok {
+				continue
+			}
+			if property, changed := modifiedProperty(other, snapshot); changed {
+				errs = append(errs, &ModuleError{
+					BlueprintError: BlueprintError{
+						Err: fmt.Errorf("parallel mutator %q modified property %q of %s "+
+							"(defined at %s) while visiting this module",
+							s.mutator, property, other, other.pos),
+						Pos: module.pos,
+					},
+					module: module,
+				})
+				// Only report each modification once.
+				s.snapshots[other] = snapshotProperties(other)
+			}
+		}
+	}
+
+	delete(s.snapshots, module)
+	for _, m := range visitedModules(module) {
+		s.snapshots[m] = snapshotProperties(m)
+	}
+
+	return errs
+}
+
+// visitedModules returns module, or the variants it was split into by the mutator.
+func visitedModules(module *moduleInfo) []*moduleInfo {
+	if module.splitModules != nil {
+		return module.splitModules
+	}
+	return []*moduleInfo{module}
+}
+
+// modifiedProperty returns the name of the first property of module that differs from snapshot.
+func modifiedProperty(module *moduleInfo, snapshot []interface{}) (string, bool) {
+	for i, p := range module.properties {
+		property, changed := changedProperty("", reflect.ValueOf(snapshot[i]).Elem(),
+			reflect.ValueOf(p).Elem())
+		if changed {
+			return property, true
+		}
+	}
+	return "", false
+}
+
+// changedProperty returns the name of the first property that differs between the struct values
+// a and b.
+func changedProperty(prefix string, a, b reflect.Value) (string, bool) {
+	for i := 0; i < a.NumField(); i++ {
+		field := a.Type().Field(i)
+		if field.PkgPath != "" {
+			continue
+		}
+
+		name := prefix + proptools.PropertyNameForField(field.Name)
+		aField, bField := a.Field(i), b.Field(i)
+
+		if field.Anonymous && aField.Kind() == reflect.Struct {
+			if property, changed := changedProperty(prefix, aField, bField); changed {
+				return property, true
+			}
+			continue
+		}
+
+		if aField.Kind() == reflect.Ptr && !aField.IsNil() && !bField.IsNil() &&
+			aField.Elem().Kind() == reflect.Struct {
+			aField, bField = aField.Elem(), bField.Elem()
+		}
+
+		if aField.Kind() == reflect.Struct {
+			if property, changed := changedProperty(name+".", aField, bField); changed {
+				return property, true
+			}
+			continue
+		}
+
+		if !reflect.DeepEqual(aField.Interface(), bField.Interface()) {
+			return name, true
+		}
+	}
+	return "", false
+}
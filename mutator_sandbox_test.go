@@ -0,0 +1,105 @@
+// Copyright 2020 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blueprint
+
+import (
+	"testing"
+)
+
+func TestCheckParallelMutators(t *testing.T) {
+	setFooOnDeps := func(ctx BaseModuleContext) {
+		ctx.VisitDirectDeps(func(dep Module) {
+			dep.(*fooModule).properties.Foo = "modified by " + ctx.ModuleName()
+		})
+	}
+
+	testCases := []struct {
+		name     string
+		register func(ctx *Context)
+		errs     []string
+	}{
+		{
+			name: "own properties",
+			register: func(ctx *Context) {
+				ctx.RegisterBottomUpMutator("split", func(ctx BottomUpMutatorContext) {
+					ctx.Module().(*fooModule).properties.Foo = "modified"
+					for _, variant := range ctx.CreateVariations("a", "b") {
+						variant.(*fooModule).properties.Foo = "variant"
+					}
+				}).Parallel()
+			},
+		},
+		{
+			name: "bottom up",
+			register: func(ctx *Context) {
+				ctx.RegisterBottomUpMutator("modify_deps", func(ctx BottomUpMutatorContext) {
+					setFooOnDeps(ctx)
+				}).Parallel()
+			},
+			errs: []string{
+				`Blueprints:2:6: module "A": parallel mutator "modify_deps" modified property "foo" ` +
+					`of module "B" (defined at Blueprints:7:6) while visiting this module`,
+			},
+		},
+		{
+			name: "top down",
+			register: func(ctx *Context) {
+				ctx.RegisterTopDownMutator("modify_deps", func(ctx TopDownMutatorContext) {
+					setFooOnDeps(ctx)
+				}).Parallel()
+			},
+			errs: []string{
+				`Blueprints:2:6: module "A": parallel mutator "modify_deps" modified property "foo" ` +
+					`of module "B" (defined at Blueprints:7:6) while visiting this module`,
+			},
+		},
+		{
+			name: "not parallel",
+			register: func(ctx *Context) {
+				ctx.RegisterBottomUpMutator("modify_deps", func(ctx BottomUpMutatorContext) {
+					setFooOnDeps(ctx)
+				})
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := NewContext()
+			ctx.SetCheckParallelMutators(true)
+			ctx.RegisterModuleType("foo_module", newFooModule)
+			ctx.RegisterBottomUpMutator("deps", depsMutator)
+			tc.register(ctx)
+			ctx.MockFileSystem(map[string][]byte{
+				"Blueprints": []byte(`
+					foo_module {
+						name: "A",
+						deps: ["B"],
+					}
+
+					foo_module {
+						name: "B",
+					}
+				`),
+			})
+
+			_, errs := ctx.ParseBlueprintsFiles("Blueprints", nil)
+			if len(errs) == 0 {
+				_, errs = ctx.ResolveDependencies(nil)
+			}
+			expectedErrors(t, errs, tc.errs...)
+		})
+	}
+}
@@ -0,0 +1,367 @@
+// Copyright 2020 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blueprint
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// DirectoryNameInterface is a NameInterface that places modules into namespaces based on the
+// directory of the Blueprints file that defines them.  A directory declares a namespace with a
+// module created by NamespaceModuleFactory, which must be the first module in its Blueprints
+// file:
+//
+//	blueprint_namespace {
+//	    imports: ["path/to/other/namespace"],
+//	}
+//
+// Every module in the directory and its subdirectories belongs to the namespace, unless a
+// subdirectory declares its own namespace.  Modules outside of any declared namespace belong to
+// the root namespace.  Module names only need to be unique within a namespace.
+//
+// A module name used by a module, for example in a dependency, is looked up in the module's own
+// namespace, then in the namespaces it imports, and finally in the root namespace.  A name that is
+// defined in more than one imported namespace is ambiguous and is reported as an error.  A module
+// in any namespace can be referred to with a fully qualified name of the form "//path:name", or
+// "//:name" for a module in the root namespace.
+type DirectoryNameInterface struct {
+	root       *directoryNamespace
+	namespaces map[string]*directoryNamespace
+
+	// dirsWithModules contains the directories that have had a module other than a namespace
+	// module defined in them.
+	dirsWithModules map[string]bool
+}
+
+type directoryNamespace struct {
+	NamespaceMarker
+
+	path    string
+	imports []string
+	modules map[string]ModuleGroup
+
+	// module is the namespace module that declared the namespace, nil for the root namespace.
+	module *moduleInfo
+}
+
+// NewDirectoryNameInterface returns a DirectoryNameInterface with only the root namespace.  Pass it
+// to Context.SetNameInterface and register NamespaceModuleFactory as a module type before parsing
+// any Blueprints files.
+func NewDirectoryNameInterface() *DirectoryNameInterface {
+	root := newDirectoryNamespace(".")
+	return &DirectoryNameInterface{
+		root:            root,
+		namespaces:      map[string]*directoryNamespace{".": root},
+		dirsWithModules: make(map[string]bool),
+	}
+}
+
+func newDirectoryNamespace(path string) *directoryNamespace {
+	return &directoryNamespace{
+		path:    path,
+		modules: make(map[string]ModuleGroup),
+	}
+}
+
+// namespaceModuleName is the name of every namespace module, which is unique within its namespace
+// as each namespace is declared by a single namespace module.
+const namespaceModuleName = "blueprint_namespace"
+
+type namespaceModule struct {
+	properties struct {
+		// Imports lists the paths of the namespaces whose modules can be referred to by name.
+		Imports []string
+	}
+}
+
+// NamespaceModuleFactory is the ModuleFactory for the module type that declares a namespace in a
+// DirectoryNameInterface.
+func NamespaceModuleFactory() (Module, []interface{}) {
+	m := &namespaceModule{}
+	return m, []interface{}{&m.properties}
+}
+
+func (m *namespaceModule) Name() string {
+	return namespaceModuleName
+}
+
+func (m *namespaceModule) GenerateBuildActions(ModuleContext) {
+}
+
+func namespaceDir(ctx NamespaceContext) string {
+	return filepath.Clean(filepath.Dir(ctx.ModulePath()))
+}
+
+// parseQualifiedName splits a name of the form "//path:name" into the namespace path and the
+// module name.
+func parseQualifiedName(name string) (path, moduleName string, ok bool) {
+	if !strings.HasPrefix(name, "//") {
+		return "", "", false
+	}
+	i := strings.LastIndex(name, ":")
+	if i == -1 {
+		return "", "", false
+	}
+	path = name[len("//"):i]
+	if path == "" {
+		path = "."
+	}
+	return filepath.Clean(path), name[i+1:], true
+}
+
+func (d *DirectoryNameInterface) NewModule(ctx NamespaceContext, group ModuleGroup,
+	module Module) (Namespace, []error) {
+
+	dir := namespaceDir(ctx)
+
+	if m, ok := module.(*namespaceModule); ok {
+		if existing, exists := d.namespaces[dir]; exists && existing.module != nil {
+			return nil, []error{
+				// seven characters at the start of the second line to align with the string "error: "
+				fmt.Errorf("namespace %q already defined\n"+
+					"       %s <-- previous definition here", dir, existing.module.pos),
+			}
+		}
+		if dir == "." {
+			return nil, []error{fmt.Errorf("a namespace cannot be declared in the root directory")}
+		}
+		if d.dirsWithModules[dir] {
+			return nil, []error{fmt.Errorf("a namespace must be the first module in its Blueprints file")}
+		}
+
+		namespace := newDirectoryNamespace(dir)
+		namespace.module = group.modules[0]
+		for _, path := range m.properties.Imports {
+			namespace.imports = append(namespace.imports, filepath.Clean(strings.TrimPrefix(path, "//")))
+		}
+		d.namespaces[dir] = namespace
+		namespace.modules[group.name] = group
+		return namespace, nil
+	}
+
+	d.dirsWithModules[dir] = true
+
+	namespace := d.namespaceForDir(dir)
+	if existing, exists := namespace.modules[group.name]; exists {
+		return nil, []error{
+			// seven characters at the start of the second line to align with the string "error: "
+			fmt.Errorf("module %q already defined\n"+
+				"       %s <-- previous definition here", group.name, existing.modules[0].pos),
+		}
+	}
+
+	namespace.modules[group.name] = group
+	return namespace, nil
+}
+
+// namespaceForDir returns the namespace declared in dir or the closest of its parent directories,
+// or the root namespace.
+func (d *DirectoryNameInterface) namespaceForDir(dir string) *directoryNamespace {
+	for {
+		if namespace, ok := d.namespaces[dir]; ok {
+			return namespace
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return d.root
+		}
+		dir = parent
+	}
+}
+
+func (d *DirectoryNameInterface) fromNamespace(namespace Namespace) *directoryNamespace {
+	if n, ok := namespace.(*directoryNamespace); ok && n != nil {
+		return n
+	}
+	return d.root
+}
+
+// lookup finds the module moduleName as seen from the namespace from.  If the name is ambiguous it
+// returns the imported namespaces that define it.
+func (d *DirectoryNameInterface) lookup(moduleName string,
+	from *directoryNamespace) (group ModuleGroup, found bool, ambiguous []string) {
+
+	if path, name, ok := parseQualifiedName(moduleName); ok {
+		if namespace, exists := d.namespaces[path]; exists {
+			group, found = namespace.modules[name]
+		}
+		return group, found, nil
+	}
+
+	if group, found = from.modules[moduleName]; found {
+		return group, true, nil
+	}
+
+	var matches []string
+	for _, path := range from.imports {
+		if namespace, exists := d.namespaces[path]; exists {
+			if g, ok := namespace.modules[moduleName]; ok {
+				group = g
+				matches = append(matches, path)
+			}
+		}
+	}
+	if len(matches) == 1 {
+		return group, true, nil
+	} else if len(matches) > 1 {
+		return ModuleGroup{}, false, matches
+	}
+
+	group, found = d.root.modules[moduleName]
+	return group, found, nil
+}
+
+func (d *DirectoryNameInterface) ModuleFromName(moduleName string,
+	namespace Namespace) (group ModuleGroup, found bool) {
+
+	group, found, _ = d.lookup(moduleName, d.fromNamespace(namespace))
+	return group, found
+}
+
+func (d *DirectoryNameInterface) MissingDependencyError(depender string, dependerNamespace Namespace,
+	dependency string) error {
+
+	from := d.fromNamespace(dependerNamespace)
+
+	if path, _, ok := parseQualifiedName(dependency); ok {
+		if _, exists := d.namespaces[path]; !exists {
+			return fmt.Errorf("%q depends on undefined module %q, namespace %q does not exist",
+				depender, dependency, path)
+		}
+		return fmt.Errorf("%q depends on undefined module %q", depender, dependency)
+	}
+
+	if _, _, ambiguous := d.lookup(dependency, from); len(ambiguous) > 0 {
+		var qualified []string
+		for _, path := range ambiguous {
+			qualified = append(qualified, fmt.Sprintf("%q", qualifiedName(path, dependency)))
+		}
+		return fmt.Errorf("%q depends on %q, which is ambiguous because it is defined in more "+
+			"than one imported namespace, use one of %s", depender, dependency,
+			strings.Join(qualified, ", "))
+	}
+
+	var elsewhere []string
+	for _, namespace := range d.sortedNamespaces() {
+		if _, ok := namespace.modules[dependency]; ok {
+			elsewhere = append(elsewhere, fmt.Sprintf("%q", qualifiedName(namespace.path, dependency)))
+		}
+	}
+	if len(elsewhere) > 0 {
+		return fmt.Errorf("%q depends on undefined module %q in namespace %q, "+
+			"but it is defined as %s, which is not imported", depender, dependency, from.path,
+			strings.Join(elsewhere, ", "))
+	}
+
+	return fmt.Errorf("%q depends on undefined module %q", depender, dependency)
+}
+
+func qualifiedName(path, name string) string {
+	if path == "." {
+		return "//:" + name
+	}
+	return "//" + path + ":" + name
+}
+
+func (d *DirectoryNameInterface) Rename(oldName string, newName string,
+	namespace Namespace) (errs []error) {
+
+	n := d.fromNamespace(namespace)
+
+	existingGroup, exists := n.modules[newName]
+	if exists {
+		return []error{
+			// seven characters at the start of the second line to align with the string "error: "
+			fmt.Errorf("renaming module %q to %q conflicts with existing module\n"+
+				"       %s <-- existing module defined here",
+				oldName, newName, existingGroup.modules[0].pos),
+		}
+	}
+
+	group, exists := n.modules[oldName]
+	if !exists {
+		return []error{fmt.Errorf("module %q to renamed to %q doesn't exist", oldName, newName)}
+	}
+	n.modules[newName] = group
+	delete(n.modules, group.name)
+	group.name = newName
+	return nil
+}
+
+// sortedNamespaces returns all of the namespaces, starting with the root namespace, sorted by
+// path.
+func (d *DirectoryNameInterface) sortedNamespaces() []*directoryNamespace {
+	namespaces := make([]*directoryNamespace, 0, len(d.namespaces))
+	for _, namespace := range d.namespaces {
+		namespaces = append(namespaces, namespace)
+	}
+	sort.Slice(namespaces, func(i, j int) bool {
+		return namespaces[i].path < namespaces[j].path
+	})
+	return namespaces
+}
+
+func (d *DirectoryNameInterface) AllModules() []ModuleGroup {
+	var groups []ModuleGroup
+	for _, namespace := range d.sortedNamespaces() {
+		names := make([]string, 0, len(namespace.modules))
+		for name := range namespace.modules {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			groups = append(groups, namespace.modules[name])
+		}
+	}
+	return groups
+}
+
+func (d *DirectoryNameInterface) GetNamespace(ctx NamespaceContext) Namespace {
+	return d.namespaceForDir(namespaceDir(ctx))
+}
+
+func (d *DirectoryNameInterface) UniqueName(ctx NamespaceContext, name string) (unique string) {
+	namespace := d.namespaceForDir(namespaceDir(ctx))
+	if namespace == d.root {
+		return name
+	}
+	return qualifiedName(namespace.path, name)
+}
+
+// verifyNamespaces is called by Context.ResolveDependencies after all Blueprints files have been
+// parsed to report imports of namespaces that do not exist.
+func (d *DirectoryNameInterface) verifyNamespaces() (errs []error) {
+	for _, namespace := range d.sortedNamespaces() {
+		for _, path := range namespace.imports {
+			if _, exists := d.namespaces[path]; !exists {
+				errs = append(errs, &PropertyError{
+					ModuleError: ModuleError{
+						BlueprintError: BlueprintError{
+							Err: fmt.Errorf("namespace %q imports undefined namespace %q",
+								namespace.path, path),
+							Pos: namespace.module.propertyPos["imports"],
+						},
+						module: namespace.module,
+					},
+					property: "imports",
+				})
+			}
+		}
+	}
+	return errs
+}
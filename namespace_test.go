@@ -0,0 +1,187 @@
+// Copyright 2020 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blueprint
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestDirectoryNameInterface(t *testing.T) {
+	testCases := []struct {
+		name string
+		fs   map[string]string
+		deps map[string][]string
+		errs []string
+	}{
+		{
+			name: "lookup",
+			fs: map[string]string{
+				"Blueprints": `
+					foo_module { name: "root_lib" }
+					foo_module { name: "common" }
+				`,
+				"a/Blueprints": `
+					blueprint_namespace {}
+					foo_module { name: "lib" }
+					foo_module { name: "common" }
+				`,
+				"b/Blueprints": `
+					blueprint_namespace {}
+					foo_module { name: "lib" }
+				`,
+				"c/Blueprints": `
+					blueprint_namespace { imports: ["a"] }
+					foo_module { name: "c1", deps: ["lib", "root_lib", "common", "//b:lib", "//:common"] }
+				`,
+				"c/sub/Blueprints": `
+					foo_module { name: "c2", deps: ["c1", "lib"] }
+				`,
+			},
+			deps: map[string][]string{
+				"//c:c1": {"//a:lib", "root_lib", "//a:common", "//b:lib", "common"},
+				"//c:c2": {"//c:c1", "//a:lib"},
+			},
+		},
+		{
+			name: "ambiguous",
+			fs: map[string]string{
+				"a/Blueprints": `
+					blueprint_namespace {}
+					foo_module { name: "lib" }
+				`,
+				"b/Blueprints": `
+					blueprint_namespace {}
+					foo_module { name: "lib" }
+				`,
+				"c/Blueprints": `
+					blueprint_namespace { imports: ["a", "//b"] }
+					foo_module { name: "c1", deps: ["lib"] }
+				`,
+			},
+			errs: []string{
+				`c/Blueprints:3:6: "c1" depends on "lib", which is ambiguous because it is defined ` +
+					`in more than one imported namespace, use one of "//a:lib", "//b:lib"`,
+			},
+		},
+		{
+			name: "not imported",
+			fs: map[string]string{
+				"a/Blueprints": `
+					blueprint_namespace {}
+					foo_module { name: "lib" }
+				`,
+				"c/Blueprints": `
+					blueprint_namespace {}
+					foo_module { name: "c1", deps: ["lib", "//d:lib"] }
+				`,
+			},
+			errs: []string{
+				`c/Blueprints:3:6: "c1" depends on undefined module "lib" in namespace "c", ` +
+					`but it is defined as "//a:lib", which is not imported`,
+				`c/Blueprints:3:6: "c1" depends on undefined module "//d:lib", namespace "d" does not exist`,
+			},
+		},
+		{
+			name: "missing import",
+			fs: map[string]string{
+				"c/Blueprints": `
+					blueprint_namespace { imports: ["d"] }
+				`,
+			},
+			errs: []string{
+				`c/Blueprints:2:35: module "blueprint_namespace": imports: namespace "c" imports undefined namespace "d"`,
+			},
+		},
+		{
+			name: "duplicate",
+			fs: map[string]string{
+				"a/Blueprints": `
+					blueprint_namespace {}
+					foo_module { name: "lib" }
+					foo_module { name: "lib" }
+				`,
+			},
+			errs: []string{
+				"a/Blueprints:4:6: module \"lib\" already defined\n" +
+					"       a/Blueprints:3:6 <-- previous definition here",
+			},
+		},
+		{
+			name: "not first",
+			fs: map[string]string{
+				"a/Blueprints": `
+					foo_module { name: "lib" }
+					blueprint_namespace {}
+				`,
+			},
+			errs: []string{
+				`a/Blueprints:3:6: a namespace must be the first module in its Blueprints file`,
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := NewContext()
+			ctx.SetNameInterface(NewDirectoryNameInterface())
+			ctx.RegisterModuleType("blueprint_namespace", NamespaceModuleFactory)
+			ctx.RegisterModuleType("foo_module", newFooModule)
+			ctx.RegisterBottomUpMutator("deps", depsMutator)
+
+			fs := make(map[string][]byte)
+			var files []string
+			for name, contents := range tc.fs {
+				fs[name] = []byte(contents)
+				files = append(files, name)
+			}
+			sort.Strings(files)
+			ctx.MockFileSystem(fs)
+
+			_, errs := ctx.ParseFileList(".", files, nil)
+			if len(errs) == 0 {
+				_, errs = ctx.ResolveDependencies(nil)
+			}
+			expectedErrors(t, errs, tc.errs...)
+			if len(errs) > 0 {
+				return
+			}
+
+			uniqueName := func(module *moduleInfo) string {
+				return ctx.nameInterface.UniqueName(newNamespaceContext(module), module.Name())
+			}
+
+			found := 0
+			for _, module := range ctx.modulesSorted {
+				want, ok := tc.deps[uniqueName(module)]
+				if !ok {
+					continue
+				}
+				found++
+				var got []string
+				for _, dep := range module.directDeps {
+					got = append(got, uniqueName(dep.module))
+				}
+				if !reflect.DeepEqual(got, want) {
+					t.Errorf("module %s: expected deps %q, got %q", uniqueName(module), want, got)
+				}
+			}
+			if found != len(tc.deps) {
+				t.Errorf("expected to find %d modules, found %d", len(tc.deps), found)
+			}
+		})
+	}
+}
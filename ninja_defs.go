@@ -66,6 +66,11 @@ type RuleParams struct {
 	Rspfile        string // The response file.
 	RspfileContent string // The response file content.
 
+	// Dyndep is the dynamic dependency file that Ninja loads before running the rule.  It must
+	// name an input of every build statement that uses the rule, and requires Ninja 1.10.0, which
+	// must be set with SingletonContext.RequireNinjaVersion.
+	Dyndep string
+
 	// These fields are used internally in Blueprint
 	CommandDeps      []string // Command-specific implicit dependencies to prepend to builds
 	CommandOrderOnly []string // Command-specific order-only dependencies to prepend to builds
@@ -89,6 +94,17 @@ type BuildParams struct {
 	OrderOnly       []string          // The list of order-only dependencies.
 	Args            map[string]string // The variable/value pairs to set.
 	Optional        bool              // Skip outputting a default statement
+
+	// Dyndep is the dynamic dependency file that Ninja loads before running the build statement.
+	// It is added to the order-only dependencies if it is not already an input, and requires Ninja
+	// 1.10.0, which must be set with SingletonContext.RequireNinjaVersion.
+	Dyndep string
+
+	// Validations are targets that Ninja builds whenever the outputs of the build statement are
+	// built, but that the build statement does not depend on, so they are not in its critical path
+	// and can't cause it to rerun.  They are intended for lint and check actions, and require Ninja
+	// 1.11.0, which must be set with SingletonContext.RequireNinjaVersion.
+	Validations []string
 }
 
 // A poolDef describes a pool definition.  It does not include the name of the
@@ -179,6 +195,14 @@ func parseRuleParams(scope scope, params *RuleParams) (*ruleDef,
 		r.Variables["description"] = value
 	}
 
+	if params.Dyndep != "" {
+		value, err = parseNinjaString(scope, params.Dyndep)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing Dyndep param: %s", err)
+		}
+		r.Variables["dyndep"] = value
+	}
+
 	if params.Generator {
 		r.Variables["generator"] = simpleNinjaString("true")
 	}
@@ -257,9 +281,15 @@ type buildDef struct {
 	Inputs          []ninjaString
 	Implicits       []ninjaString
 	OrderOnly       []ninjaString
+	Validations     []ninjaString
 	Args            map[Variable]ninjaString
 	Variables       map[string]ninjaString
+	Dyndep          ninjaString
 	Optional        bool
+
+	// Caller is the Go function and source location that called Build, if
+	// SetBuildLocationComments was enabled.
+	Caller string
 }
 
 func parseBuildParams(scope scope, params *BuildParams) (*buildDef,
@@ -314,6 +344,11 @@ func parseBuildParams(scope scope, params *BuildParams) (*buildDef,
 		return nil, fmt.Errorf("error parsing OrderOnly param: %s", err)
 	}
 
+	b.Validations, err = parseNinjaStrings(scope, params.Validations)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing Validations param: %s", err)
+	}
+
 	b.Optional = params.Optional
 
 	if params.Depfile != "" {
@@ -336,6 +371,15 @@ func parseBuildParams(scope scope, params *BuildParams) (*buildDef,
 		setVariable("description", value)
 	}
 
+	if params.Dyndep != "" {
+		value, err := parseNinjaString(scope, params.Dyndep)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing Dyndep param: %s", err)
+		}
+		b.Dyndep = value
+		setVariable("dyndep", value)
+	}
+
 	argNameScope := rule.scope()
 
 	if len(params.Args) > 0 {
@@ -373,6 +417,7 @@ func (b *buildDef) WriteTo(nw *ninjaWriter, pkgNames map[*packageContext]string)
 		explicitDeps  = valueList(b.Inputs, pkgNames, inputEscaper)
 		implicitDeps  = valueList(b.Implicits, pkgNames, inputEscaper)
 		orderOnlyDeps = valueList(b.OrderOnly, pkgNames, inputEscaper)
+		validations   = valueList(b.Validations, pkgNames, inputEscaper)
 	)
 
 	if b.RuleDef != nil {
@@ -380,7 +425,13 @@ func (b *buildDef) WriteTo(nw *ninjaWriter, pkgNames map[*packageContext]string)
 		orderOnlyDeps = append(valueList(b.RuleDef.CommandOrderOnly, pkgNames, inputEscaper), orderOnlyDeps...)
 	}
 
-	err := nw.Build(comment, rule, outputs, implicitOuts, explicitDeps, implicitDeps, orderOnlyDeps)
+	var dyndep string
+	if b.Dyndep != nil {
+		dyndep = b.Dyndep.ValueWithEscaper(pkgNames, inputEscaper)
+	}
+
+	err := nw.Build(comment, rule, outputs, implicitOuts, explicitDeps, implicitDeps, orderOnlyDeps,
+		validations, dyndep)
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,117 @@
+// Copyright 2020 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blueprint
+
+import (
+	"fmt"
+)
+
+// A ninjaFeature is a feature of the Ninja file format that is only supported by newer versions
+// of Ninja.  Build statements can only use it if a singleton has called RequireNinjaVersion with
+// at least the version that introduced it, otherwise older versions of Ninja would fail to parse
+// or silently misinterpret the generated file.
+type ninjaFeature struct {
+	name                string
+	major, minor, micro int
+
+	// usedBy returns true if the build statement uses the feature.
+	usedBy func(def *buildDef) bool
+}
+
+var ninjaFeatures = []ninjaFeature{
+	{
+		name:  "dyndep",
+		major: 1, minor: 10, micro: 0,
+		usedBy: func(def *buildDef) bool {
+			if def.Dyndep != nil {
+				return true
+			}
+			if def.RuleDef != nil {
+				_, ok := def.RuleDef.Variables["dyndep"]
+				return ok
+			}
+			return false
+		},
+	},
+	{
+		name:  "validations",
+		major: 1, minor: 11, micro: 0,
+		usedBy: func(def *buildDef) bool {
+			return len(def.Validations) > 0
+		},
+	},
+}
+
+func (f ninjaFeature) supportedBy(major, minor, micro int) bool {
+	if major != f.major {
+		return major > f.major
+	}
+	if minor != f.minor {
+		return minor > f.minor
+	}
+	return micro >= f.micro
+}
+
+// checkNinjaFeatures returns an error for each module or singleton that generated a build
+// statement using a feature that is not supported by the version of Ninja required by the
+// Context.
+func (c *Context) checkNinjaFeatures() (errs []error) {
+	var unsupported []ninjaFeature
+	for _, feature := range ninjaFeatures {
+		if !feature.supportedBy(c.requiredNinjaMajor, c.requiredNinjaMinor, c.requiredNinjaMicro) {
+			unsupported = append(unsupported, feature)
+		}
+	}
+	if len(unsupported) == 0 {
+		return nil
+	}
+
+	check := func(actions *localBuildActions) error {
+		for _, def := range actions.buildDefs {
+			for _, feature := range unsupported {
+				if feature.usedBy(def) {
+					return fmt.Errorf("build statement for %q uses %s, which requires ninja %d.%d.%d, "+
+						"but the required ninja version is %d.%d.%d; call RequireNinjaVersion(%d, %d, %d) "+
+						"from a singleton",
+						def.Outputs[0].Value(c.pkgNames), feature.name,
+						feature.major, feature.minor, feature.micro,
+						c.requiredNinjaMajor, c.requiredNinjaMinor, c.requiredNinjaMicro,
+						feature.major, feature.minor, feature.micro)
+				}
+			}
+		}
+		return nil
+	}
+
+	for _, module := range c.modulesSortedByName() {
+		if err := check(&module.actionDefs); err != nil {
+			errs = append(errs, &ModuleError{
+				BlueprintError: BlueprintError{
+					Err: err,
+					Pos: module.pos,
+				},
+				module: module,
+			})
+		}
+	}
+
+	for _, info := range c.singletonInfo {
+		if err := check(&info.actionDefs); err != nil {
+			errs = append(errs, fmt.Errorf("singleton %q: %s", info.name, err))
+		}
+	}
+
+	return errs
+}
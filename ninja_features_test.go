@@ -0,0 +1,211 @@
+// Copyright 2020 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blueprint
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+var (
+	ninjaFeaturesPctx = NewPackageContext("github.com/google/blueprint/ninja_features_test")
+
+	ninjaFeaturesScan = ninjaFeaturesPctx.StaticRule("scan", RuleParams{
+		Command: "scan $in > $out",
+	})
+
+	ninjaFeaturesCompile = ninjaFeaturesPctx.StaticRule("compile", RuleParams{
+		Command: "compile $in -o $out",
+	})
+
+	ninjaFeaturesCompileDyndep = ninjaFeaturesPctx.StaticRule("compile_dyndep", RuleParams{
+		Command: "compile $in -o $out",
+		Dyndep:  "$out.dd",
+	})
+)
+
+type ninjaFeaturesModule struct {
+	SimpleName
+	properties struct {
+		Dyndep      bool
+		Rule_dyndep bool
+		Validations []string
+	}
+}
+
+func newNinjaFeaturesModule() (Module, []interface{}) {
+	m := &ninjaFeaturesModule{}
+	return m, []interface{}{&m.properties, &m.SimpleName.Properties}
+}
+
+func (m *ninjaFeaturesModule) GenerateBuildActions(ctx ModuleContext) {
+	out := ctx.ModuleName() + ".o"
+	ctx.Build(ninjaFeaturesPctx, BuildParams{
+		Rule:    ninjaFeaturesScan,
+		Outputs: []string{out + ".dd"},
+		Inputs:  []string{ctx.ModuleName() + ".f90"},
+	})
+
+	if m.properties.Rule_dyndep {
+		ctx.Build(ninjaFeaturesPctx, BuildParams{
+			Rule:        ninjaFeaturesCompileDyndep,
+			Outputs:     []string{out},
+			Inputs:      []string{ctx.ModuleName() + ".f90"},
+			Implicits:   []string{out + ".dd"},
+			Validations: m.properties.Validations,
+		})
+	} else {
+		params := BuildParams{
+			Rule:        ninjaFeaturesCompile,
+			Outputs:     []string{out},
+			Inputs:      []string{ctx.ModuleName() + ".f90"},
+			Validations: m.properties.Validations,
+		}
+		if m.properties.Dyndep {
+			params.Dyndep = out + ".dd"
+		}
+		ctx.Build(ninjaFeaturesPctx, params)
+	}
+}
+
+type ninjaVersionSingleton struct {
+	major, minor, micro int
+}
+
+func (s *ninjaVersionSingleton) GenerateBuildActions(ctx SingletonContext) {
+	ctx.RequireNinjaVersion(s.major, s.minor, s.micro)
+}
+
+func runNinjaFeaturesTest(bp string, major, minor, micro int) (*Context, []error) {
+	ctx := NewContext()
+	ctx.RegisterModuleType("test", newNinjaFeaturesModule)
+	ctx.RegisterSingletonType("ninja_version", func() Singleton {
+		return &ninjaVersionSingleton{major, minor, micro}
+	})
+	ctx.MockFileSystem(map[string][]byte{
+		"Blueprints": []byte(bp),
+	})
+
+	_, errs := ctx.ParseBlueprintsFiles("Blueprints", nil)
+	if len(errs) == 0 {
+		_, errs = ctx.ResolveDependencies(nil)
+	}
+	if len(errs) == 0 {
+		_, _, errs = ctx.PrepareBuildActions(nil)
+	}
+	return ctx, errs
+}
+
+func writeNinjaFeaturesTest(t *testing.T, ctx *Context) string {
+	t.Helper()
+	buf := &bytes.Buffer{}
+	if err := ctx.WriteBuildFile(buf); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	return buf.String()
+}
+
+func TestDyndep(t *testing.T) {
+	bp := `
+		test {
+			name: "a",
+			dyndep: true,
+		}
+
+		test {
+			name: "b",
+			rule_dyndep: true,
+		}
+	`
+
+	t.Run("supported", func(t *testing.T) {
+		ctx, errs := runNinjaFeaturesTest(bp, 1, 10, 0)
+		if len(errs) > 0 {
+			t.Fatalf("unexpected errors: %q", errs)
+		}
+
+		out := writeNinjaFeaturesTest(t, ctx)
+
+		for _, want := range []string{
+			"ninja_required_version = 1.10.0\n",
+			"build a.o: g.ninja_features_test.compile a.f90 || a.o.dd\n    dyndep = a.o.dd\n",
+			"build b.o: g.ninja_features_test.compile_dyndep b.f90 | b.o.dd\n",
+			"rule g.ninja_features_test.compile_dyndep\n    command = compile ${in} -o ${out}\n" +
+				"    dyndep = ${out}.dd\n",
+		} {
+			if !strings.Contains(out, want) {
+				t.Errorf("expected build file to contain %q, got:\n%s", want, out)
+			}
+		}
+
+		var b Module
+		ctx.VisitAllModules(func(m Module) {
+			if ctx.ModuleName(m) == "b" {
+				b = m
+			}
+		})
+		statements, err := ctx.ModuleBuildStatements(b)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if g, w := statements[1].Dyndep, "b.o.dd"; g != w {
+			t.Errorf("expected dyndep %q, got %q", w, g)
+		}
+	})
+
+	t.Run("unsupported", func(t *testing.T) {
+		_, errs := runNinjaFeaturesTest(bp, 1, 9, 0)
+		expectedErrors(t, errs,
+			`Blueprints:2:3: module "a": build statement for "a.o" uses dyndep, which requires `+
+				`ninja 1.10.0, but the required ninja version is 1.9.0; call RequireNinjaVersion(1, 10, 0) `+
+				`from a singleton`,
+			`Blueprints:7:3: module "b": build statement for "b.o" uses dyndep, which requires `+
+				`ninja 1.10.0, but the required ninja version is 1.9.0; call RequireNinjaVersion(1, 10, 0) `+
+				`from a singleton`,
+		)
+	})
+}
+
+func TestValidations(t *testing.T) {
+	bp := `
+		test {
+			name: "a",
+			validations: ["a.lint"],
+		}
+	`
+
+	t.Run("supported", func(t *testing.T) {
+		ctx, errs := runNinjaFeaturesTest(bp, 1, 11, 0)
+		if len(errs) > 0 {
+			t.Fatalf("unexpected errors: %q", errs)
+		}
+
+		out := writeNinjaFeaturesTest(t, ctx)
+		want := "build a.o: g.ninja_features_test.compile a.f90 |@ a.lint\n"
+		if !strings.Contains(out, want) {
+			t.Errorf("expected build file to contain %q, got:\n%s", want, out)
+		}
+	})
+
+	t.Run("unsupported", func(t *testing.T) {
+		_, errs := runNinjaFeaturesTest(bp, 1, 10, 0)
+		expectedErrors(t, errs,
+			`Blueprints:2:3: module "a": build statement for "a.o" uses validations, which requires `+
+				`ninja 1.11.0, but the required ninja version is 1.10.0; call RequireNinjaVersion(1, 11, 0) `+
+				`from a singleton`,
+		)
+	})
+}
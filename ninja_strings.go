@@ -41,13 +41,37 @@ type ninjaString interface {
 	Variables() []Variable
 }
 
+// A varNinjaString is a ninjaString that references variables.  Instead of splitting the string into
+// a separate string for each literal part, which dominated memory use on large trees, it holds the
+// string it was parsed from and the position of each variable reference in it.
 type varNinjaString struct {
-	strings   []string
-	variables []Variable
+	// str is the string that was parsed, with a "$" prepended if it started with a space.
+	str string
+
+	// variables are the variable references in str, in order.
+	variables []variableReference
+}
+
+// A variableReference is a reference to a variable in a varNinjaString.
+type variableReference struct {
+	// start and end are the offsets in the string of the reference, including the "$" and any
+	// braces.
+	start, end int32
+
+	variable Variable
 }
 
 type literalNinjaString string
 
+// variableNinjaString returns a ninjaString that is a reference to v.
+func variableNinjaString(v Variable, pkgNames map[*packageContext]string) ninjaString {
+	str := "${" + v.fullName(pkgNames) + "}"
+	return &varNinjaString{
+		str:       str,
+		variables: []variableReference{{start: 0, end: int32(len(str)), variable: v}},
+	}
+}
+
 type scope interface {
 	LookupVariable(name string) (Variable, error)
 	IsRuleVisible(rule Rule) bool
@@ -58,41 +82,11 @@ func simpleNinjaString(str string) ninjaString {
 	return literalNinjaString(str)
 }
 
-type parseState struct {
-	scope       scope
-	str         string
-	pendingStr  string
-	stringStart int
-	varStart    int
-	result      *varNinjaString
-}
-
-func (ps *parseState) pushVariable(v Variable) {
-	if len(ps.result.variables) == len(ps.result.strings) {
-		// Last push was a variable, we need a blank string separator
-		ps.result.strings = append(ps.result.strings, "")
-	}
-	if ps.pendingStr != "" {
-		panic("oops, pushed variable with pending string")
-	}
-	ps.result.variables = append(ps.result.variables, v)
-}
-
-func (ps *parseState) pushString(s string) {
-	if len(ps.result.strings) != len(ps.result.variables) {
-		panic("oops, pushed string after string")
-	}
-	ps.result.strings = append(ps.result.strings, ps.pendingStr+s)
-	ps.pendingStr = ""
-}
-
-type stateFunc func(*parseState, int, rune) (stateFunc, error)
-
 // parseNinjaString parses an unescaped ninja string (i.e. all $<something>
 // occurrences are expected to be variables or $$) and returns a list of the
 // variable names that the string references.
 func parseNinjaString(scope scope, str string) (ninjaString, error) {
-	// naively pre-allocate slices by counting $ signs
+	// naively pre-allocate the variable references by counting $ signs
 	n := strings.Count(str, "$")
 	if n == 0 {
 		if strings.HasPrefix(str, " ") {
@@ -100,170 +94,93 @@ func parseNinjaString(scope scope, str string) (ninjaString, error) {
 		}
 		return literalNinjaString(str), nil
 	}
-	result := &varNinjaString{
-		strings:   make([]string, 0, n+1),
-		variables: make([]Variable, 0, n),
-	}
 
-	parseState := &parseState{
-		scope:  scope,
-		str:    str,
-		result: result,
+	result := &varNinjaString{
+		str:       str,
+		variables: make([]variableReference, 0, n),
 	}
 
-	state := parseFirstRuneState
-	var err error
 	for i := 0; i < len(str); i++ {
-		r := rune(str[i])
-		state, err = state(parseState, i, r)
-		if err != nil {
-			return nil, err
+		if str[i] != '$' {
+			continue
 		}
-	}
-
-	_, err = state(parseState, len(parseState.str), eof)
-	if err != nil {
-		return nil, err
-	}
-
-	return result, nil
-}
-
-func parseFirstRuneState(state *parseState, i int, r rune) (stateFunc, error) {
-	if r == ' ' {
-		state.pendingStr += "$"
-	}
-	return parseStringState(state, i, r)
-}
-
-func parseStringState(state *parseState, i int, r rune) (stateFunc, error) {
-	switch {
-	case r == '$':
-		state.varStart = i + 1
-		return parseDollarStartState, nil
 
-	case r == eof:
-		state.pushString(state.str[state.stringStart:i])
-		return nil, nil
-
-	default:
-		return parseStringState, nil
-	}
-}
-
-func parseDollarStartState(state *parseState, i int, r rune) (stateFunc, error) {
-	switch {
-	case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z',
-		r >= '0' && r <= '9', r == '_', r == '-':
-		// The beginning of a of the variable name.  Output the string and
-		// keep going.
-		state.pushString(state.str[state.stringStart : i-1])
-		return parseDollarState, nil
-
-	case r == '$':
-		// Just a "$$".  Go back to parseStringState without changing
-		// state.stringStart.
-		return parseStringState, nil
-
-	case r == '{':
-		// This is a bracketted variable name (e.g. "${blah.blah}").  Output
-		// the string and keep going.
-		state.pushString(state.str[state.stringStart : i-1])
-		state.varStart = i + 1
-		return parseBracketsState, nil
-
-	case r == eof:
-		return nil, fmt.Errorf("unexpected end of string after '$'")
-
-	default:
-		// This was some arbitrary character following a dollar sign,
-		// which is not allowed.
-		return nil, fmt.Errorf("invalid character after '$' at byte "+
-			"offset %d", i)
-	}
-}
-
-func parseDollarState(state *parseState, i int, r rune) (stateFunc, error) {
-	switch {
-	case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z',
-		r >= '0' && r <= '9', r == '_', r == '-':
-		// A part of the variable name.  Keep going.
-		return parseDollarState, nil
-
-	case r == '$':
-		// A dollar after the variable name (e.g. "$blah$").  Output the
-		// variable we have and start a new one.
-		v, err := state.scope.LookupVariable(state.str[state.varStart:i])
-		if err != nil {
-			return nil, err
+		start := i
+		i++
+		if i == len(str) {
+			return nil, fmt.Errorf("unexpected end of string after '$'")
 		}
 
-		state.pushVariable(v)
-		state.varStart = i + 1
-		state.stringStart = i
-
-		return parseDollarStartState, nil
-
-	case r == eof:
-		// This is the end of the variable name.
-		v, err := state.scope.LookupVariable(state.str[state.varStart:i])
-		if err != nil {
-			return nil, err
+		var name string
+		switch c := str[i]; {
+		case c == '$':
+			// Just a "$$".
+			continue
+
+		case isNinjaVariableNameChar(c):
+			end := i + 1
+			for end < len(str) && isNinjaVariableNameChar(str[end]) {
+				end++
+			}
+			name = str[i:end]
+			i = end - 1
+
+		case c == '{':
+			// This is a bracketted variable name (e.g. "${blah.blah}").
+			nameStart := i + 1
+			end := nameStart
+			for end < len(str) && (isNinjaVariableNameChar(str[end]) || str[end] == '.') {
+				end++
+			}
+			if end == len(str) {
+				return nil, fmt.Errorf("unexpected end of string in variable name")
+			}
+			if str[end] != '}' {
+				// This character isn't allowed in a variable name.
+				return nil, fmt.Errorf("invalid character in variable name at "+
+					"byte offset %d", end)
+			}
+			if end == nameStart {
+				// The brackets were immediately closed.  That's no good.
+				return nil, fmt.Errorf("empty variable name at byte offset %d",
+					end)
+			}
+			name = str[nameStart:end]
+			i = end
+
+		default:
+			// This was some arbitrary character following a dollar sign,
+			// which is not allowed.
+			return nil, fmt.Errorf("invalid character after '$' at byte "+
+				"offset %d", i)
 		}
 
-		state.pushVariable(v)
-
-		// We always end with a string, even if it's an empty one.
-		state.pushString("")
-
-		return nil, nil
-
-	default:
-		// We've just gone past the end of the variable name, so record what
-		// we have.
-		v, err := state.scope.LookupVariable(state.str[state.varStart:i])
+		v, err := scope.LookupVariable(name)
 		if err != nil {
 			return nil, err
 		}
 
-		state.pushVariable(v)
-		state.stringStart = i
-		return parseStringState, nil
+		result.variables = append(result.variables, variableReference{
+			start:    int32(start),
+			end:      int32(i + 1),
+			variable: v,
+		})
 	}
-}
-
-func parseBracketsState(state *parseState, i int, r rune) (stateFunc, error) {
-	switch {
-	case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z',
-		r >= '0' && r <= '9', r == '_', r == '-', r == '.':
-		// A part of the variable name.  Keep going.
-		return parseBracketsState, nil
-
-	case r == '}':
-		if state.varStart == i {
-			// The brackets were immediately closed.  That's no good.
-			return nil, fmt.Errorf("empty variable name at byte offset %d",
-				i)
-		}
 
-		// This is the end of the variable name.
-		v, err := state.scope.LookupVariable(state.str[state.varStart:i])
-		if err != nil {
-			return nil, err
+	if str[0] == ' ' {
+		result.str = "$" + str
+		for i := range result.variables {
+			result.variables[i].start++
+			result.variables[i].end++
 		}
+	}
 
-		state.pushVariable(v)
-		state.stringStart = i + 1
-		return parseStringState, nil
-
-	case r == eof:
-		return nil, fmt.Errorf("unexpected end of string in variable name")
+	return result, nil
+}
 
-	default:
-		// This character isn't allowed in a variable name.
-		return nil, fmt.Errorf("invalid character in variable name at "+
-			"byte offset %d", i)
-	}
+func isNinjaVariableNameChar(c byte) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') ||
+		(c >= '0' && c <= '9') || c == '_' || c == '-'
 }
 
 func parseNinjaStrings(scope scope, strs []string) ([]ninjaString,
@@ -283,47 +200,68 @@ func parseNinjaStrings(scope scope, strs []string) ([]ninjaString,
 	return result, nil
 }
 
-func (n varNinjaString) Value(pkgNames map[*packageContext]string) string {
+func (n *varNinjaString) Value(pkgNames map[*packageContext]string) string {
 	return n.ValueWithEscaper(pkgNames, defaultEscaper)
 }
 
-func (n varNinjaString) ValueWithEscaper(pkgNames map[*packageContext]string,
+func (n *varNinjaString) ValueWithEscaper(pkgNames map[*packageContext]string,
 	escaper *strings.Replacer) string {
 
-	if len(n.strings) == 1 {
-		return escaper.Replace(n.strings[0])
+	if len(n.variables) == 0 {
+		return escaper.Replace(n.str)
 	}
 
-	str := strings.Builder{}
-	str.WriteString(escaper.Replace(n.strings[0]))
-	for i, v := range n.variables {
+	// Leave room for the variable names to expand to their full names.
+	str := &strings.Builder{}
+	str.Grow(len(n.str) + 16*len(n.variables))
+	prev := int32(0)
+	for _, ref := range n.variables {
+		escaper.WriteString(str, n.str[prev:ref.start])
 		str.WriteString("${")
-		str.WriteString(v.fullName(pkgNames))
+		str.WriteString(ref.variable.fullName(pkgNames))
 		str.WriteString("}")
-		str.WriteString(escaper.Replace(n.strings[i+1]))
+		prev = ref.end
 	}
+	escaper.WriteString(str, n.str[prev:])
 
 	return str.String()
 }
 
-func (n varNinjaString) Eval(variables map[Variable]ninjaString) (string, error) {
-	str := n.strings[0]
-	for i, v := range n.variables {
-		variable, ok := variables[v]
+func (n *varNinjaString) Eval(variables map[Variable]ninjaString) (string, error) {
+	if len(n.variables) == 0 {
+		return n.str, nil
+	}
+
+	str := &strings.Builder{}
+	str.Grow(len(n.str))
+	prev := int32(0)
+	for _, ref := range n.variables {
+		str.WriteString(n.str[prev:ref.start])
+		variable, ok := variables[ref.variable]
 		if !ok {
-			return "", fmt.Errorf("no such global variable: %s", v)
+			return "", fmt.Errorf("no such global variable: %s", ref.variable)
 		}
 		value, err := variable.Eval(variables)
 		if err != nil {
 			return "", err
 		}
-		str += value + n.strings[i+1]
+		str.WriteString(value)
+		prev = ref.end
 	}
-	return str, nil
+	str.WriteString(n.str[prev:])
+
+	return str.String(), nil
 }
 
-func (n varNinjaString) Variables() []Variable {
-	return n.variables
+func (n *varNinjaString) Variables() []Variable {
+	if len(n.variables) == 0 {
+		return nil
+	}
+	variables := make([]Variable, len(n.variables))
+	for i, ref := range n.variables {
+		variables[i] = ref.variable
+	}
+	return variables
 }
 
 func (l literalNinjaString) Value(pkgNames map[*packageContext]string) string {
@@ -15,6 +15,8 @@
 package blueprint
 
 import (
+	"fmt"
+	"io/ioutil"
 	"reflect"
 	"strconv"
 	"strings"
@@ -122,25 +124,23 @@ func TestParseNinjaString(t *testing.T) {
 			expectedVars = append(expectedVars, v)
 		}
 
-		var expected ninjaString
-		if len(testCase.strs) > 0 {
-			if testCase.literal {
-				expected = literalNinjaString(testCase.strs[0])
-			} else {
-				expected = &varNinjaString{
-					strings:   testCase.strs,
-					variables: expectedVars,
-				}
-			}
-		}
-
 		output, err := parseNinjaString(scope, testCase.input)
 		if err == nil {
-			if !reflect.DeepEqual(output, expected) {
+			var strs []string
+			var vars []Variable
+			literal := false
+			switch output := output.(type) {
+			case literalNinjaString:
+				strs, vars, literal = []string{string(output)}, []Variable{}, true
+			case *varNinjaString:
+				strs, vars = ninjaStringParts(output)
+			}
+			if literal != testCase.literal || !reflect.DeepEqual(strs, testCase.strs) ||
+				!reflect.DeepEqual(vars, expectedVars) {
 				t.Errorf("incorrect ninja string:")
 				t.Errorf("     input: %q", testCase.input)
-				t.Errorf("  expected: %#v", expected)
-				t.Errorf("       got: %#v", output)
+				t.Errorf("  expected: %q %#v literal=%t", testCase.strs, expectedVars, testCase.literal)
+				t.Errorf("       got: %q %#v literal=%t", strs, vars, literal)
 			}
 		}
 		var errStr string
@@ -156,6 +156,21 @@ func TestParseNinjaString(t *testing.T) {
 	}
 }
 
+// ninjaStringParts returns the literal strings between the variable references in a
+// varNinjaString, and the variables.
+func ninjaStringParts(n *varNinjaString) ([]string, []Variable) {
+	strs := make([]string, 0, len(n.variables)+1)
+	vars := make([]Variable, 0, len(n.variables))
+	prev := int32(0)
+	for _, ref := range n.variables {
+		strs = append(strs, n.str[prev:ref.start])
+		vars = append(vars, ref.variable)
+		prev = ref.end
+	}
+	strs = append(strs, n.str[prev:])
+	return strs, vars
+}
+
 func TestParseNinjaStringWithImportedVar(t *testing.T) {
 	ImpVar := &staticVariable{name_: "ImpVar"}
 	impScope := newScope(nil)
@@ -170,7 +185,7 @@ func TestParseNinjaStringWithImportedVar(t *testing.T) {
 	}
 
 	expect := []Variable{ImpVar}
-	if !reflect.DeepEqual(output.(*varNinjaString).variables, expect) {
+	if !reflect.DeepEqual(output.Variables(), expect) {
 		t.Errorf("incorrect output:")
 		t.Errorf("     input: %q", input)
 		t.Errorf("  expected: %#v", expect)
@@ -217,5 +232,134 @@ func BenchmarkNinjaString_Value(b *testing.B) {
 			})
 		}
 	})
+}
+
+var benchmarkNinjaStrings = []string{
+	"${outDir}/obj/foo/bar.o",
+	"${cc} -c ${cflags} -I${includeDir} -o $out $in",
+	"${outDir}/gen/${arch}/foo/bar$$baz.h",
+	" leading space ${outDir}",
+	"src/foo/bar/baz.cpp",
+}
+
+func benchmarkNinjaStringScope() *localScope {
+	scope := newLocalScope(nil, "")
+	for _, name := range []string{"outDir", "cc", "cflags", "includeDir", "out", "in", "arch"} {
+		scope.AddLocalVariable(name, "value_of_"+name)
+	}
+	return scope
+}
+
+func BenchmarkParseNinjaString(b *testing.B) {
+	scope := benchmarkNinjaStringScope()
+	b.ReportAllocs()
+	for n := 0; n < b.N; n++ {
+		for _, str := range benchmarkNinjaStrings {
+			if _, err := parseNinjaString(scope, str); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
+
+func BenchmarkNinjaString_Eval(b *testing.B) {
+	scope := benchmarkNinjaStringScope()
+	variables := make(map[Variable]ninjaString)
+	for _, name := range []string{"outDir", "cc", "cflags", "includeDir", "out", "in", "arch"} {
+		v, _ := scope.LookupVariable(name)
+		variables[v] = simpleNinjaString("value_of_" + name)
+	}
+	strs, err := parseNinjaStrings(scope, benchmarkNinjaStrings)
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ReportAllocs()
+	for n := 0; n < b.N; n++ {
+		for _, str := range strs {
+			if _, err := str.Eval(variables); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
 
+func BenchmarkNinjaString_ValueWithEscaper(b *testing.B) {
+	scope := benchmarkNinjaStringScope()
+	strs, err := parseNinjaStrings(scope, benchmarkNinjaStrings)
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ReportAllocs()
+	for n := 0; n < b.N; n++ {
+		for _, str := range strs {
+			str.ValueWithEscaper(nil, outputEscaper)
+		}
+	}
+}
+
+var (
+	ninjaStringsBenchPctx = NewPackageContext("github.com/google/blueprint/ninja_strings_test")
+
+	ninjaStringsBenchOutDir = ninjaStringsBenchPctx.StaticVariable("outDir", "out")
+
+	ninjaStringsBenchCompile = ninjaStringsBenchPctx.StaticRule("compile", RuleParams{
+		Command: "cc -c $cflags -o $out $in",
+	}, "cflags")
+)
+
+type ninjaStringsBenchModule struct {
+	SimpleName
+}
+
+func (m *ninjaStringsBenchModule) GenerateBuildActions(ctx ModuleContext) {
+	name := ctx.ModuleName()
+	for i := 0; i < 10; i++ {
+		src := name + "/src" + strconv.Itoa(i) + ".c"
+		ctx.Build(ninjaStringsBenchPctx, BuildParams{
+			Rule:    ninjaStringsBenchCompile,
+			Outputs: []string{"${outDir}/" + name + "/obj" + strconv.Itoa(i) + ".o"},
+			Inputs:  []string{src},
+			Args: map[string]string{
+				"cflags": "-O2 -I${outDir}/" + name + "/include -DNAME=" + name,
+			},
+		})
+	}
+}
+
+// BenchmarkBuildActions measures PrepareBuildActions and WriteBuildFile for modules that generate
+// many build statements, which is dominated by parsing, tracking and writing Ninja strings.
+func BenchmarkBuildActions(b *testing.B) {
+	bp := &strings.Builder{}
+	for i := 0; i < 1000; i++ {
+		fmt.Fprintf(bp, "test { name: \"m%d\" }\n", i)
+	}
+
+	b.ReportAllocs()
+	for n := 0; n < b.N; n++ {
+		b.StopTimer()
+		ctx := NewContext()
+		ctx.RegisterModuleType("test", func() (Module, []interface{}) {
+			m := &ninjaStringsBenchModule{}
+			return m, []interface{}{&m.SimpleName.Properties}
+		})
+		ctx.MockFileSystem(map[string][]byte{
+			"Blueprints": []byte(bp.String()),
+		})
+		_, errs := ctx.ParseBlueprintsFiles("Blueprints", nil)
+		if len(errs) == 0 {
+			_, errs = ctx.ResolveDependencies(nil)
+		}
+		if len(errs) > 0 {
+			b.Fatal(errs)
+		}
+		b.StartTimer()
+
+		_, _, errs = ctx.PrepareBuildActions(nil)
+		if len(errs) > 0 {
+			b.Fatal(errs)
+		}
+		if err := ctx.WriteBuildFile(ioutil.Discard); err != nil {
+			b.Fatal(err)
+		}
+	}
 }
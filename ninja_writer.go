@@ -15,7 +15,6 @@
 package blueprint
 
 import (
-	"fmt"
 	"io"
 	"strings"
 	"unicode"
@@ -27,25 +26,115 @@ const (
 	lineWidth      = 80
 )
 
+// ninjaWriterBufferSize is the size of the buffer that a ninjaWriter fills before writing it to the
+// underlying writer.  The buffer starts at ninjaWriterMinBufferSize and grows as needed, so that
+// writers for small files don't allocate the whole buffer.
+const (
+	ninjaWriterMinBufferSize = 4 << 10
+	ninjaWriterBufferSize    = 1 << 20
+)
+
 var indentString = strings.Repeat(" ", indentWidth*maxIndentDepth)
 
+// A ninjaWriter writes Ninja statements to a buffer of up to ninjaWriterBufferSize that is written to the underlying writer
+// whenever it fills up, so Flush must be called after the last statement.  Errors from the
+// underlying writer are returned by the next call to any method.
 type ninjaWriter struct {
 	writer io.Writer
+	buf    []byte
+	err    error
+
+	// lineWidth is the width that build statements, default statements and comments are wrapped
+	// at, or 0 if they are not wrapped.
+	lineWidth int
+
+	// lineLen is the length of the current line of a wrapped statement.
+	lineLen int
 
 	justDidBlankLine bool // true if the last operation was a BlankLine
 }
 
 func newNinjaWriter(writer io.Writer) *ninjaWriter {
 	return &ninjaWriter{
-		writer: writer,
+		writer:    writer,
+		lineWidth: lineWidth,
+	}
+}
+
+// SetLineWrapping sets whether long statements and comments are wrapped to make them easier to
+// read.  Wrapping is enabled by default.
+func (n *ninjaWriter) SetLineWrapping(wrap bool) {
+	if wrap {
+		n.lineWidth = lineWidth
+	} else {
+		n.lineWidth = 0
+	}
+}
+
+// reserve makes room in the buffer for size more bytes, growing the buffer up to
+// ninjaWriterBufferSize or writing it to the underlying writer first if necessary.
+func (n *ninjaWriter) reserve(size int) {
+	needed := len(n.buf) + size
+	if needed <= cap(n.buf) {
+		return
+	}
+
+	if needed <= ninjaWriterBufferSize {
+		newCap := 2 * cap(n.buf)
+		if newCap < ninjaWriterMinBufferSize {
+			newCap = ninjaWriterMinBufferSize
+		}
+		for newCap < needed {
+			newCap *= 2
+		}
+		if newCap > ninjaWriterBufferSize {
+			newCap = ninjaWriterBufferSize
+		}
+		n.buf = append(make([]byte, 0, newCap), n.buf...)
+		return
 	}
+
+	n.flush()
+	if size > cap(n.buf) {
+		n.buf = make([]byte, 0, size)
+	}
+}
+
+func (n *ninjaWriter) flush() {
+	if n.err == nil && len(n.buf) > 0 {
+		_, n.err = n.writer.Write(n.buf)
+	}
+	n.buf = n.buf[:0]
+}
+
+// Flush writes any buffered statements to the underlying writer.
+func (n *ninjaWriter) Flush() error {
+	n.flush()
+	return n.err
+}
+
+// writeLine writes the concatenation of strs followed by a newline.
+func (n *ninjaWriter) writeLine(strs ...string) error {
+	n.justDidBlankLine = false
+
+	size := 1
+	for _, s := range strs {
+		size += len(s)
+	}
+	n.reserve(size)
+
+	for _, s := range strs {
+		n.buf = append(n.buf, s...)
+	}
+	n.buf = append(n.buf, '\n')
+	return n.err
 }
 
 func (n *ninjaWriter) Comment(comment string) error {
 	n.justDidBlankLine = false
 
 	const lineHeaderLen = len("# ")
-	const maxLineLen = lineWidth - lineHeaderLen
+	maxLineLen := n.lineWidth - lineHeaderLen
 
 	var lineStart, lastSplitPoint int
 	for i, r := range comment {
@@ -63,7 +152,7 @@ func (n *ninjaWriter) Comment(comment string) error {
 			line = strings.TrimRightFunc(comment[lineStart:i], unicode.IsSpace)
 			writeLine = true
 
-		case (i-lineStart > maxLineLen) && (lastSplitPoint > lineStart):
+		case n.lineWidth > 0 && (i-lineStart > maxLineLen) && (lastSplitPoint > lineStart):
 			// The line has grown too long and is splittable.  Split it at the
 			// last split point.
 			line = strings.TrimSpace(comment[lineStart:lastSplitPoint])
@@ -71,198 +160,188 @@ func (n *ninjaWriter) Comment(comment string) error {
 		}
 
 		if writeLine {
-			line = strings.TrimSpace("# "+line) + "\n"
-			_, err := io.WriteString(n.writer, line)
-			if err != nil {
-				return err
+			if line == "" {
+				n.writeLine("#")
+			} else {
+				n.writeLine("# ", line)
 			}
 			lineStart = lastSplitPoint
 		}
 	}
 
 	if lineStart != len(comment) {
-		line := strings.TrimSpace(comment[lineStart:])
-		_, err := fmt.Fprintf(n.writer, "# %s\n", line)
-		if err != nil {
-			return err
-		}
+		n.writeLine("# ", strings.TrimSpace(comment[lineStart:]))
 	}
 
-	return nil
+	return n.err
 }
 
 func (n *ninjaWriter) Pool(name string) error {
-	n.justDidBlankLine = false
-	_, err := fmt.Fprintf(n.writer, "pool %s\n", name)
-	return err
+	return n.writeLine("pool ", name)
 }
 
 func (n *ninjaWriter) Rule(name string) error {
-	n.justDidBlankLine = false
-	_, err := fmt.Fprintf(n.writer, "rule %s\n", name)
-	return err
+	return n.writeLine("rule ", name)
 }
 
+// Build writes a build statement.  If dyndep is not empty it is added to the order-only
+// dependencies unless it is already one of the inputs, as Ninja requires the dyndep file to be an
+// input of the build statement.  The dyndep binding itself must be written with ScopedAssign.
 func (n *ninjaWriter) Build(comment string, rule string, outputs, implicitOuts,
-	explicitDeps, implicitDeps, orderOnlyDeps []string) error {
+	explicitDeps, implicitDeps, orderOnlyDeps, validations []string, dyndep string) error {
 
 	n.justDidBlankLine = false
 
-	const lineWrapLen = len(" $")
-	const maxLineLen = lineWidth - lineWrapLen
-
-	wrapper := ninjaWriterWithWrap{
-		ninjaWriter: n,
-		maxLineLen:  maxLineLen,
-	}
-
 	if comment != "" {
-		err := wrapper.Comment(comment)
+		err := n.Comment(comment)
 		if err != nil {
 			return err
 		}
 	}
 
-	wrapper.WriteString("build")
+	if dyndep != "" && !inList(dyndep, explicitDeps) && !inList(dyndep, implicitDeps) &&
+		!inList(dyndep, orderOnlyDeps) {
+		orderOnlyDeps = append(orderOnlyDeps[:len(orderOnlyDeps):len(orderOnlyDeps)], dyndep)
+	}
+
+	lists := [...][]string{outputs, implicitOuts, explicitDeps, implicitDeps, orderOnlyDeps,
+		validations}
+	size := len("build: ") + len(rule) + len(" | || |@\n")
+	words := 1
+	for _, list := range lists {
+		for _, s := range list {
+			size += len(s) + 1
+		}
+		words += len(list)
+	}
+	if n.lineWidth > 0 {
+		// Leave room for every word to start a new line.
+		size += words * len(" $\n"+indentString)
+	}
+	n.reserve(size)
+
+	n.startStatement("build")
 
 	for _, output := range outputs {
-		wrapper.WriteStringWithSpace(output)
+		n.writeWord(output, true)
 	}
 
 	if len(implicitOuts) > 0 {
-		wrapper.WriteStringWithSpace("|")
+		n.writeWord("|", true)
 
 		for _, out := range implicitOuts {
-			wrapper.WriteStringWithSpace(out)
+			n.writeWord(out, true)
 		}
 	}
 
-	wrapper.WriteString(":")
+	n.writeWord(":", false)
 
-	wrapper.WriteStringWithSpace(rule)
+	n.writeWord(rule, true)
 
 	for _, dep := range explicitDeps {
-		wrapper.WriteStringWithSpace(dep)
+		n.writeWord(dep, true)
 	}
 
 	if len(implicitDeps) > 0 {
-		wrapper.WriteStringWithSpace("|")
+		n.writeWord("|", true)
 
 		for _, dep := range implicitDeps {
-			wrapper.WriteStringWithSpace(dep)
+			n.writeWord(dep, true)
 		}
 	}
 
 	if len(orderOnlyDeps) > 0 {
-		wrapper.WriteStringWithSpace("||")
+		n.writeWord("||", true)
 
 		for _, dep := range orderOnlyDeps {
-			wrapper.WriteStringWithSpace(dep)
+			n.writeWord(dep, true)
 		}
 	}
 
-	return wrapper.Flush()
+	if len(validations) > 0 {
+		n.writeWord("|@", true)
+
+		for _, validation := range validations {
+			n.writeWord(validation, true)
+		}
+	}
+
+	n.buf = append(n.buf, '\n')
+	return n.err
 }
 
 func (n *ninjaWriter) Assign(name, value string) error {
-	n.justDidBlankLine = false
-	_, err := fmt.Fprintf(n.writer, "%s = %s\n", name, value)
-	return err
+	return n.writeLine(name, " = ", value)
 }
 
 func (n *ninjaWriter) ScopedAssign(name, value string) error {
-	n.justDidBlankLine = false
-	_, err := fmt.Fprintf(n.writer, "%s%s = %s\n", indentString[:indentWidth], name, value)
-	return err
+	return n.writeLine(indentString[:indentWidth], name, " = ", value)
 }
 
 func (n *ninjaWriter) Default(targets ...string) error {
 	n.justDidBlankLine = false
 
-	const lineWrapLen = len(" $")
-	const maxLineLen = lineWidth - lineWrapLen
-
-	wrapper := ninjaWriterWithWrap{
-		ninjaWriter: n,
-		maxLineLen:  maxLineLen,
+	size := len("default\n")
+	for _, target := range targets {
+		size += len(target) + 1
+	}
+	if n.lineWidth > 0 {
+		size += len(targets) * len(" $\n"+indentString)
 	}
+	n.reserve(size)
 
-	wrapper.WriteString("default")
+	n.startStatement("default")
 
 	for _, target := range targets {
-		wrapper.WriteString(" " + target)
+		n.writeWord(target, true)
 	}
 
-	return wrapper.Flush()
+	n.buf = append(n.buf, '\n')
+	return n.err
 }
 
 func (n *ninjaWriter) Subninja(file string) error {
-	n.justDidBlankLine = false
-	_, err := fmt.Fprintf(n.writer, "subninja %s\n", file)
-	return err
+	return n.writeLine("subninja ", file)
 }
 
 func (n *ninjaWriter) BlankLine() (err error) {
 	// We don't output multiple blank lines in a row.
 	if !n.justDidBlankLine {
 		n.justDidBlankLine = true
-		_, err = io.WriteString(n.writer, "\n")
+		n.reserve(1)
+		n.buf = append(n.buf, '\n')
 	}
-	return err
+	return n.err
 }
 
-type ninjaWriterWithWrap struct {
-	*ninjaWriter
-	maxLineLen int
-	writtenLen int
-	err        error
+// startStatement writes the first word of a statement that is wrapped by writeWord.  The buffer must
+// already have room for the statement.
+func (n *ninjaWriter) startStatement(s string) {
+	n.buf = append(n.buf, s...)
+	n.lineLen = len(s)
 }
 
-func (n *ninjaWriterWithWrap) writeString(s string, space bool) {
-	if n.err != nil {
-		return
-	}
+// writeWord writes a word of a statement, preceded by a space if space is true.  If line wrapping
+// is enabled and the word would make the line too long it is written on a new line.  The buffer
+// must already have room for the word.
+func (n *ninjaWriter) writeWord(s string, space bool) {
+	const lineWrapLen = len(" $")
 
 	spaceLen := 0
 	if space {
 		spaceLen = 1
 	}
 
-	if n.writtenLen+len(s)+spaceLen > n.maxLineLen {
-		_, n.err = io.WriteString(n.writer, " $\n")
-		if n.err != nil {
-			return
-		}
-		_, n.err = io.WriteString(n.writer, indentString[:indentWidth*2])
-		if n.err != nil {
-			return
-		}
-		n.writtenLen = indentWidth * 2
+	if n.lineWidth > 0 && n.lineLen+len(s)+spaceLen > n.lineWidth-lineWrapLen {
+		n.buf = append(n.buf, " $\n"...)
+		n.buf = append(n.buf, indentString[:indentWidth*2]...)
+		n.lineLen = indentWidth * 2
 		s = strings.TrimLeftFunc(s, unicode.IsSpace)
 	} else if space {
-		_, n.err = io.WriteString(n.writer, " ")
-		if n.err != nil {
-			return
-		}
-		n.writtenLen++
+		n.buf = append(n.buf, ' ')
+		n.lineLen++
 	}
 
-	_, n.err = io.WriteString(n.writer, s)
-	n.writtenLen += len(s)
-}
-
-func (n *ninjaWriterWithWrap) WriteString(s string) {
-	n.writeString(s, false)
-}
-
-func (n *ninjaWriterWithWrap) WriteStringWithSpace(s string) {
-	n.writeString(s, true)
-}
-
-func (n *ninjaWriterWithWrap) Flush() error {
-	if n.err != nil {
-		return n.err
-	}
-	_, err := io.WriteString(n.writer, "\n")
-	return err
+	n.buf = append(n.buf, s...)
+	n.lineLen += len(s)
 }
@@ -16,6 +16,10 @@ package blueprint
 
 import (
 	"bytes"
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
 	"testing"
 )
 
@@ -50,10 +54,30 @@ var ninjaWriterTestCases = []struct {
 	{
 		input: func(w *ninjaWriter) {
 			ck(w.Build("foo comment", "foo", []string{"o1", "o2"}, []string{"io1", "io2"},
-				[]string{"e1", "e2"}, []string{"i1", "i2"}, []string{"oo1", "oo2"}))
+				[]string{"e1", "e2"}, []string{"i1", "i2"}, []string{"oo1", "oo2"}, nil, ""))
 		},
 		output: "# foo comment\nbuild o1 o2 | io1 io2: foo e1 e2 | i1 i2 || oo1 oo2\n",
 	},
+	{
+		input: func(w *ninjaWriter) {
+			ck(w.Build("", "foo", []string{"o1"}, nil, []string{"e1"}, []string{"i1"}, []string{"oo1"},
+				[]string{"v1", "v2"}, ""))
+		},
+		output: "build o1: foo e1 | i1 || oo1 |@ v1 v2\n",
+	},
+	{
+		input: func(w *ninjaWriter) {
+			ck(w.Build("", "foo", []string{"o1"}, nil, []string{"e1"}, nil, nil, nil, "o1.dd"))
+			ck(w.ScopedAssign("dyndep", "o1.dd"))
+		},
+		output: "build o1: foo e1 || o1.dd\n    dyndep = o1.dd\n",
+	},
+	{
+		input: func(w *ninjaWriter) {
+			ck(w.Build("", "foo", []string{"o1"}, nil, []string{"e1"}, []string{"o1.dd"}, nil, nil, "o1.dd"))
+		},
+		output: "build o1: foo e1 | o1.dd\n",
+	},
 	{
 		input: func(w *ninjaWriter) {
 			ck(w.Default("foo"))
@@ -94,7 +118,7 @@ var ninjaWriterTestCases = []struct {
 			ck(w.ScopedAssign("command", "echo out: $out in: $in _arg: $_arg"))
 			ck(w.ScopedAssign("pool", "p"))
 			ck(w.BlankLine())
-			ck(w.Build("r comment", "r", []string{"foo.o"}, nil, []string{"foo.in"}, nil, nil))
+			ck(w.Build("r comment", "r", []string{"foo.o"}, nil, []string{"foo.in"}, nil, nil, nil, ""))
 			ck(w.ScopedAssign("_arg", "arg value"))
 		},
 		output: `pool p
@@ -117,6 +141,7 @@ func TestNinjaWriter(t *testing.T) {
 		buf := bytes.NewBuffer(nil)
 		w := newNinjaWriter(buf)
 		testCase.input(w)
+		ck(w.Flush())
 		if buf.String() != testCase.output {
 			t.Errorf("incorrect output for test case %d", i)
 			t.Errorf("  expected: %q", testCase.output)
@@ -124,3 +149,133 @@ func TestNinjaWriter(t *testing.T) {
 		}
 	}
 }
+
+func TestNinjaWriterLineWrapping(t *testing.T) {
+	var inputs []string
+	for i := 0; i < 8; i++ {
+		inputs = append(inputs, "path/to/input"+strconv.Itoa(i)+".c")
+	}
+	comment := strings.Repeat("word ", 20)
+
+	write := func(wrap bool) string {
+		buf := &bytes.Buffer{}
+		w := newNinjaWriter(buf)
+		w.SetLineWrapping(wrap)
+		ck(w.Build(comment, "cc", []string{"out.o"}, nil, inputs, nil, nil, nil, ""))
+		ck(w.Default(inputs...))
+		ck(w.Flush())
+		return buf.String()
+	}
+
+	wrapped := "# word word word word word word word word word word word word word word word word\n" +
+		"# word word word word\n" +
+		"build out.o: cc path/to/input0.c path/to/input1.c path/to/input2.c $\n" +
+		"        path/to/input3.c path/to/input4.c path/to/input5.c path/to/input6.c $\n" +
+		"        path/to/input7.c\n" +
+		"default path/to/input0.c path/to/input1.c path/to/input2.c path/to/input3.c $\n" +
+		"        path/to/input4.c path/to/input5.c path/to/input6.c path/to/input7.c\n"
+	if got := write(true); got != wrapped {
+		t.Errorf("incorrect wrapped output:\nexpected:\n%s\ngot:\n%s", wrapped, got)
+	}
+
+	unwrapped := "# " + strings.TrimSpace(comment) + "\n" +
+		"build out.o: cc " + strings.Join(inputs, " ") + "\n" +
+		"default " + strings.Join(inputs, " ") + "\n"
+	if got := write(false); got != unwrapped {
+		t.Errorf("incorrect unwrapped output:\nexpected:\n%s\ngot:\n%s", unwrapped, got)
+	}
+}
+
+type ninjaWriterErrWriter struct{}
+
+func (ninjaWriterErrWriter) Write([]byte) (int, error) {
+	return 0, fmt.Errorf("write error")
+}
+
+func TestNinjaWriterError(t *testing.T) {
+	w := newNinjaWriter(ninjaWriterErrWriter{})
+	ck(w.Rule("r"))
+	if err := w.Flush(); err == nil || err.Error() != "write error" {
+		t.Errorf("expected write error from Flush, got %v", err)
+	}
+	if err := w.Rule("r"); err == nil {
+		t.Errorf("expected write error from Rule after failed Flush")
+	}
+}
+
+// benchmarkNinjaGraph writes a synthetic graph of build statements, each depending on a few of the
+// previous statements, similar to the compile and link steps of a large tree.
+func benchmarkNinjaGraph(b *testing.B, statements int, wrap bool) {
+	outputs := make([][]string, statements)
+	deps := make([][]string, statements)
+	for i := range outputs {
+		outputs[i] = []string{
+			fmt.Sprintf("out/intermediates/dir%d/module%d/obj/src%d.o", i%1000, i%5000, i),
+		}
+		for j := 1; j <= 4 && j <= i; j++ {
+			deps[i] = append(deps[i], outputs[i-j][0])
+		}
+	}
+	inputs := []string{"src/file.c"}
+
+	b.ResetTimer()
+	b.ReportAllocs()
+	for n := 0; n < b.N; n++ {
+		w := newNinjaWriter(ioutil.Discard)
+		w.SetLineWrapping(wrap)
+		for i := range outputs {
+			ck(w.Build("", "g.cc.compile", outputs[i], nil, inputs, deps[i], nil, nil, ""))
+			ck(w.ScopedAssign("cflags", "-O2 -Wall -Werror"))
+			ck(w.BlankLine())
+		}
+		ck(w.Flush())
+	}
+}
+
+func BenchmarkNinjaWriter(b *testing.B) {
+	for _, statements := range []int{100000, 500000} {
+		for _, wrap := range []bool{true, false} {
+			b.Run(fmt.Sprintf("%d/wrap=%t", statements, wrap), func(b *testing.B) {
+				benchmarkNinjaGraph(b, statements, wrap)
+			})
+		}
+	}
+}
+
+type ninjaWriterCountingWriter struct {
+	writes int
+	bytes.Buffer
+}
+
+func (w *ninjaWriterCountingWriter) Write(p []byte) (int, error) {
+	w.writes++
+	return w.Buffer.Write(p)
+}
+
+func TestNinjaWriterBufferSize(t *testing.T) {
+	small := &ninjaWriterCountingWriter{}
+	w := newNinjaWriter(small)
+	ck(w.Rule("r"))
+	if cap(w.buf) > ninjaWriterMinBufferSize {
+		t.Errorf("expected a small file to use a buffer of %d bytes, got %d", ninjaWriterMinBufferSize, cap(w.buf))
+	}
+	ck(w.Flush())
+	if small.writes != 1 || small.String() != "rule r\n" {
+		t.Errorf("expected a single write of %q, got %d writes of %q", "rule r\n", small.writes, small.String())
+	}
+
+	large := &ninjaWriterCountingWriter{}
+	w = newNinjaWriter(large)
+	line := strings.Repeat("x", 1000)
+	for i := 0; i < 3*ninjaWriterBufferSize/len(line); i++ {
+		ck(w.Comment(line))
+	}
+	ck(w.Flush())
+	if cap(w.buf) != ninjaWriterBufferSize {
+		t.Errorf("expected a large file to use a buffer of %d bytes, got %d", ninjaWriterBufferSize, cap(w.buf))
+	}
+	if large.writes < 3 || large.writes > 4 {
+		t.Errorf("expected 3 or 4 writes of a buffer of %d bytes for %d bytes, got %d writes",
+			ninjaWriterBufferSize, large.Len(), large.writes)
+	}
+}
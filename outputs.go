@@ -0,0 +1,213 @@
+// Copyright 2020 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blueprint
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+)
+
+// An OutputOwner describes the module or singleton that generated the build statement for an
+// output.
+type OutputOwner struct {
+	// Module is the module variant that generated the build statement, or nil if it was
+	// generated by a singleton.
+	Module Module `json:"-"`
+
+	// ModuleName, Variant and BlueprintsFile describe the module that generated the build
+	// statement, and are empty if it was generated by a singleton.
+	ModuleName     string `json:"module,omitempty"`
+	Variant        string `json:"variant,omitempty"`
+	BlueprintsFile string `json:"blueprints_file,omitempty"`
+
+	// Singleton is the name of the singleton that generated the build statement, or empty if it
+	// was generated by a module.
+	Singleton string `json:"singleton,omitempty"`
+
+	// Rule is the name of the rule of the build statement as it appears in the Ninja file.
+	Rule string `json:"rule"`
+}
+
+// OutputOwner returns the module or singleton that generated the build statement with the given
+// output or implicit output, or nil if there is no such build statement.  If this is called
+// before PrepareBuildActions successfully completes then ErrBuildActionsNotReady is returned.
+func (c *Context) OutputOwner(path string) (*OutputOwner, error) {
+	if !c.buildActionsReady {
+		return nil, ErrBuildActionsNotReady
+	}
+
+	owner, ok := c.outputOwners[path]
+	if !ok {
+		owner, ok = c.outputOwners[filepath.Clean(path)]
+	}
+	if !ok {
+		return nil, nil
+	}
+	ret := owner.export(c.pkgNames)
+	return &ret, nil
+}
+
+// WriteOutputOwners writes a JSON object that maps the path of every output and implicit output
+// of every build statement to its OutputOwner, with one output per line.  If this is called
+// before PrepareBuildActions successfully completes then ErrBuildActionsNotReady is returned.
+func (c *Context) WriteOutputOwners(w io.Writer) error {
+	if !c.buildActionsReady {
+		return ErrBuildActionsNotReady
+	}
+
+	paths := make([]string, 0, len(c.outputOwners))
+	for path := range c.outputOwners {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	buf := bufio.NewWriter(w)
+	buf.WriteString("{")
+	for i, path := range paths {
+		key, err := json.Marshal(path)
+		if err != nil {
+			return err
+		}
+		value, err := json.Marshal(c.outputOwners[path].export(c.pkgNames))
+		if err != nil {
+			return err
+		}
+		if i > 0 {
+			buf.WriteString(",")
+		}
+		fmt.Fprintf(buf, "\n  %s: %s", key, value)
+	}
+	buf.WriteString("\n}\n")
+	return buf.Flush()
+}
+
+// An outputOwner is the module or singleton that generated the build statement for an output.
+type outputOwner struct {
+	module    *moduleInfo
+	singleton *singletonInfo
+	def       *buildDef
+}
+
+func (o outputOwner) export(pkgNames map[*packageContext]string) OutputOwner {
+	ret := OutputOwner{
+		Rule: o.def.Rule.fullName(pkgNames),
+	}
+	if o.module != nil {
+		ret.Module = o.module.logicModule
+		ret.ModuleName = o.module.Name()
+		ret.Variant = o.module.variantName
+		ret.BlueprintsFile = o.module.relBlueprintsFile
+	} else {
+		ret.Singleton = o.singleton.name
+	}
+	return ret
+}
+
+func (o outputOwner) String() string {
+	if o.module != nil {
+		return fmt.Sprintf("%s defined at %s", o.module, o.module.pos)
+	}
+	return fmt.Sprintf("singleton %q", o.singleton.name)
+}
+
+// indexOutputs evaluates the outputs and implicit outputs of every build statement and returns a
+// map from each output to the module or singleton that generated it.  An output generated by more
+// than one build statement would be rejected by Ninja, so it is reported as an error naming both
+// owners.
+func (c *Context) indexOutputs() (map[string]outputOwner, []error) {
+	// The local variables of each module or singleton are added to a copy of the global variables
+	// while its outputs are evaluated.
+	variables := make(map[Variable]ninjaString, len(c.globalVariables))
+	for v, value := range c.globalVariables {
+		variables[v] = value
+	}
+
+	owners := make(map[string]outputOwner)
+	var errs []error
+
+	index := func(actions *localBuildActions, owner outputOwner) error {
+		for _, v := range actions.variables {
+			value, err := v.value(nil)
+			if err != nil {
+				return err
+			}
+			variables[v] = value
+		}
+		defer func() {
+			for _, v := range actions.variables {
+				delete(variables, v)
+			}
+		}()
+
+		for _, def := range actions.buildDefs {
+			owner.def = def
+			for _, list := range [][]ninjaString{def.Outputs, def.ImplicitOutputs} {
+				for _, output := range list {
+					path, err := output.Eval(variables)
+					if err != nil {
+						return err
+					}
+					if existing, exists := owners[path]; exists {
+						errs = append(errs, c.duplicateOutputError(path, existing, owner))
+						continue
+					}
+					owners[path] = owner
+				}
+			}
+		}
+		return nil
+	}
+
+	for _, module := range c.modulesSortedByName() {
+		if err := index(&module.actionDefs, outputOwner{module: module}); err != nil {
+			return nil, []error{err}
+		}
+	}
+
+	for _, info := range c.singletonInfo {
+		if err := index(&info.actionDefs, outputOwner{singleton: info}); err != nil {
+			return nil, []error{err}
+		}
+	}
+
+	return owners, errs
+}
+
+// duplicateOutputError returns an error for an output that is generated by both existing and
+// owner.  The error is reported on a module if either of them is a module.
+func (c *Context) duplicateOutputError(path string, existing, owner outputOwner) error {
+	if owner.module == nil && existing.module != nil {
+		existing, owner = owner, existing
+	}
+
+	err := fmt.Errorf("output %q of rule %q is also an output of rule %q of %s", path,
+		owner.def.Rule.fullName(c.pkgNames), existing.def.Rule.fullName(c.pkgNames), existing)
+
+	if owner.module == nil {
+		return fmt.Errorf("singleton %q: %s", owner.singleton.name, err)
+	}
+
+	return &ModuleError{
+		BlueprintError: BlueprintError{
+			Err: err,
+			Pos: owner.module.pos,
+		},
+		module: owner.module,
+	}
+}
@@ -0,0 +1,244 @@
+// Copyright 2020 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blueprint
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+var (
+	outputsPctx = NewPackageContext("github.com/google/blueprint/outputs_test")
+
+	outputsOutDir = outputsPctx.StaticVariable("outDir", "out")
+
+	outputsTouch = outputsPctx.StaticRule("touch", RuleParams{
+		Command: "touch $out",
+	})
+
+	outputsCopy = outputsPctx.StaticRule("cp", RuleParams{
+		Command: "cp $in $out",
+	})
+)
+
+type outputsModule struct {
+	SimpleName
+	properties struct {
+		Outs          []string
+		Implicit_outs []string
+		Copy          bool
+	}
+}
+
+func newOutputsModule() (Module, []interface{}) {
+	m := &outputsModule{}
+	return m, []interface{}{&m.properties, &m.SimpleName.Properties}
+}
+
+func (m *outputsModule) GenerateBuildActions(ctx ModuleContext) {
+	ctx.Variable(outputsPctx, "localOutDir", "${outDir}")
+
+	rule := outputsTouch
+	if m.properties.Copy {
+		rule = outputsCopy
+	}
+
+	for _, out := range m.properties.Outs {
+		ctx.Build(outputsPctx, BuildParams{
+			Rule:            rule,
+			Outputs:         []string{"${localOutDir}/" + out},
+			ImplicitOutputs: m.properties.Implicit_outs,
+		})
+	}
+}
+
+type outputsSingleton struct {
+	outs []string
+}
+
+func (s *outputsSingleton) GenerateBuildActions(ctx SingletonContext) {
+	for _, out := range s.outs {
+		ctx.Build(outputsPctx, BuildParams{
+			Rule:    Phony,
+			Outputs: []string{out},
+		})
+	}
+}
+
+func TestDuplicateOutputs(t *testing.T) {
+	testCases := []struct {
+		name       string
+		bp         string
+		singletons [][]string
+		errs       []string
+	}{
+		{
+			name: "unique",
+			bp: `
+				test {
+					name: "a",
+					outs: ["a"],
+				}
+
+				test {
+					name: "b",
+					outs: ["b"],
+					implicit_outs: ["b.d"],
+				}
+			`,
+			singletons: [][]string{{"all"}},
+		},
+		{
+			name: "modules",
+			bp: `
+				test {
+					name: "a",
+					outs: ["x"],
+				}
+
+				test {
+					name: "b",
+					outs: ["x"],
+					copy: true,
+				}
+			`,
+			errs: []string{
+				`Blueprints:7:5: module "b": output "out/x" of rule "g.outputs_test.cp" is also an ` +
+					`output of rule "g.outputs_test.touch" of module "a" defined at Blueprints:2:5`,
+			},
+		},
+		{
+			name: "implicit outputs",
+			bp: `
+				test {
+					name: "a",
+					outs: ["a", "b"],
+					implicit_outs: ["x.d"],
+				}
+			`,
+			errs: []string{
+				`Blueprints:2:5: module "a": output "x.d" of rule "g.outputs_test.touch" is also an ` +
+					`output of rule "g.outputs_test.touch" of module "a" defined at Blueprints:2:5`,
+			},
+		},
+		{
+			name: "singletons",
+			bp: `
+				test {
+					name: "a",
+					outs: ["a"],
+				}
+			`,
+			singletons: [][]string{{"out/a"}, {"all"}, {"all"}},
+			errs: []string{
+				`Blueprints:2:5: module "a": output "out/a" of rule "g.outputs_test.touch" is also an ` +
+					`output of rule "phony" of singleton "singleton0"`,
+				`singleton "singleton2": output "all" of rule "phony" is also an output of rule ` +
+					`"phony" of singleton "singleton1"`,
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := NewContext()
+			ctx.RegisterModuleType("test", newOutputsModule)
+			for i, outs := range tc.singletons {
+				singleton := &outputsSingleton{outs}
+				ctx.RegisterSingletonType("singleton"+string(rune('0'+i)),
+					func() Singleton { return singleton })
+			}
+			ctx.MockFileSystem(map[string][]byte{
+				"Blueprints": []byte(tc.bp),
+			})
+
+			_, errs := ctx.ParseBlueprintsFiles("Blueprints", nil)
+			if len(errs) == 0 {
+				_, errs = ctx.ResolveDependencies(nil)
+			}
+			if len(errs) == 0 {
+				_, _, errs = ctx.PrepareBuildActions(nil)
+			}
+			expectedErrors(t, errs, tc.errs...)
+		})
+	}
+}
+
+func TestOutputOwners(t *testing.T) {
+	ctx := NewContext()
+	ctx.RegisterModuleType("test", newOutputsModule)
+	ctx.RegisterSingletonType("singleton", func() Singleton { return &outputsSingleton{[]string{"all"}} })
+	ctx.MockFileSystem(map[string][]byte{
+		"dir/Blueprints": []byte(`
+			test {
+				name: "a",
+				outs: ["a"],
+				implicit_outs: ["a.d"],
+			}
+		`),
+	})
+
+	_, errs := ctx.ParseBlueprintsFiles("Blueprints", nil)
+	if len(errs) == 0 {
+		_, errs = ctx.ResolveDependencies(nil)
+	}
+	if len(errs) == 0 {
+		if _, err := ctx.OutputOwner("out/a"); err != ErrBuildActionsNotReady {
+			t.Errorf("expected ErrBuildActionsNotReady, got %v", err)
+		}
+		_, _, errs = ctx.PrepareBuildActions(nil)
+	}
+	if len(errs) > 0 {
+		t.Fatalf("unexpected errors: %q", errs)
+	}
+
+	var module Module
+	ctx.VisitAllModules(func(m Module) { module = m })
+
+	for _, path := range []string{"out/a", "./out/a"} {
+		owner, err := ctx.OutputOwner(path)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		want := &OutputOwner{
+			Module:         module,
+			ModuleName:     "a",
+			BlueprintsFile: "dir/Blueprints",
+			Rule:           "g.outputs_test.touch",
+		}
+		if !reflect.DeepEqual(owner, want) {
+			t.Errorf("incorrect owner of %q, expected %+v, got %+v", path, want, owner)
+		}
+	}
+
+	if owner, err := ctx.OutputOwner("out/missing"); owner != nil || err != nil {
+		t.Errorf("expected no owner of out/missing, got %+v, %v", owner, err)
+	}
+
+	buf := &bytes.Buffer{}
+	if err := ctx.WriteOutputOwners(buf); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	want := `{
+  "a.d": {"module":"a","blueprints_file":"dir/Blueprints","rule":"g.outputs_test.touch"},
+  "all": {"singleton":"singleton","rule":"phony"},
+  "out/a": {"module":"a","blueprints_file":"dir/Blueprints","rule":"g.outputs_test.touch"}
+}
+`
+	if buf.String() != want {
+		t.Errorf("incorrect output owners, expected:\n%s\ngot:\n%s", want, buf.String())
+	}
+}
@@ -0,0 +1,154 @@
+// Copyright 2020 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pathtools
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+// A FileIfChangedWriter streams the contents of a file to a temporary file next to it, and when
+// closed replaces the file with the temporary file only if their contents differ.  Like
+// WriteFileIfChanged, this leaves the timestamp of the file unchanged when its contents are the
+// same so that it can be used with ninja restat rules, but it does not need to hold the contents
+// of the file in memory, and a partially written file is never left in place of the old one.
+type FileIfChangedWriter struct {
+	filename string
+	tmp      *os.File
+	changed  bool
+	closed   bool
+}
+
+// CreateFileIfChanged returns a FileIfChangedWriter that replaces filename when it is closed if
+// its contents differ from what was written.  If the file is replaced it has the permissions perm
+// (before umask).
+func CreateFileIfChanged(filename string, perm os.FileMode) (*FileIfChangedWriter, error) {
+	dir := filepath.Dir(filename)
+	err := os.MkdirAll(dir, 0777)
+	if err != nil {
+		return nil, err
+	}
+
+	tmpName := filename + ".tmp" + strconv.Itoa(os.Getpid())
+	tmp, err := os.OpenFile(tmpName, os.O_RDWR|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return nil, err
+	}
+
+	return &FileIfChangedWriter{
+		filename: filename,
+		tmp:      tmp,
+	}, nil
+}
+
+// Write writes p to the temporary file.
+func (w *FileIfChangedWriter) Write(p []byte) (int, error) {
+	return w.tmp.Write(p)
+}
+
+// Close compares the temporary file with the file and replaces the file with it if they differ,
+// otherwise it removes the temporary file.  Changed reports which happened.
+func (w *FileIfChangedWriter) Close() error {
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+
+	changed, err := fileChanged(w.filename, w.tmp)
+	if closeErr := w.tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil || !changed {
+		os.Remove(w.tmp.Name())
+		return err
+	}
+
+	err = os.Rename(w.tmp.Name(), w.filename)
+	if err != nil {
+		os.Remove(w.tmp.Name())
+		return err
+	}
+
+	w.changed = true
+	return nil
+}
+
+// Abort removes the temporary file without replacing the file.  It does nothing if Close has
+// already been called.
+func (w *FileIfChangedWriter) Abort() {
+	if w.closed {
+		return
+	}
+	w.closed = true
+	w.tmp.Close()
+	os.Remove(w.tmp.Name())
+}
+
+// Changed returns true if Close replaced the file because its contents differed from what was
+// written, or because it did not exist.
+func (w *FileIfChangedWriter) Changed() bool {
+	return w.changed
+}
+
+// fileChanged returns true if the file at filename does not exist or differs from the contents of
+// tmp.
+func fileChanged(filename string, tmp *os.File) (bool, error) {
+	old, err := os.Open(filename)
+	if os.IsNotExist(err) {
+		return true, nil
+	} else if err != nil {
+		return false, err
+	}
+	defer old.Close()
+
+	oldInfo, err := old.Stat()
+	if err != nil {
+		return false, err
+	}
+	tmpInfo, err := tmp.Stat()
+	if err != nil {
+		return false, err
+	}
+	if oldInfo.Size() != tmpInfo.Size() {
+		return true, nil
+	}
+
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		return false, err
+	}
+
+	oldBuf := make([]byte, 64*1024)
+	tmpBuf := make([]byte, len(oldBuf))
+	for {
+		n, err := io.ReadFull(tmp, tmpBuf)
+		if err == io.EOF {
+			return false, nil
+		} else if err != nil && err != io.ErrUnexpectedEOF {
+			return false, err
+		}
+
+		if _, err := io.ReadFull(old, oldBuf[:n]); err != nil {
+			// The file changed size while it was being compared.
+			return true, nil
+		}
+
+		if !bytes.Equal(oldBuf[:n], tmpBuf[:n]) {
+			return true, nil
+		}
+	}
+}
@@ -0,0 +1,114 @@
+// Copyright 2020 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pathtools
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFileIfChangedWriter(t *testing.T) {
+	dir, err := ioutil.TempDir("", "write")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "sub", "build.ninja")
+	old := time.Now().Add(-time.Hour).Truncate(time.Second)
+
+	write := func(contents string) bool {
+		t.Helper()
+		w, err := CreateFileIfChanged(filename, 0666)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Write in pieces to exercise streaming.
+		for _, s := range strings.SplitAfter(contents, "\n") {
+			if _, err := w.Write([]byte(s)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		data, err := ioutil.ReadFile(filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != contents {
+			t.Errorf("expected contents %q, got %q", contents, data)
+		}
+
+		files, err := ioutil.ReadDir(filepath.Dir(filename))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(files) != 1 {
+			t.Errorf("expected temporary file to be removed, found %d files", len(files))
+		}
+		return w.Changed()
+	}
+
+	modTime := func() time.Time {
+		t.Helper()
+		info, err := os.Stat(filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return info.ModTime()
+	}
+
+	if !write("a\nb\n") {
+		t.Errorf("expected new file to be changed")
+	}
+
+	if err := os.Chtimes(filename, old, old); err != nil {
+		t.Fatal(err)
+	}
+
+	if write("a\nb\n") {
+		t.Errorf("expected identical file to be unchanged")
+	}
+	if !modTime().Equal(old) {
+		t.Errorf("expected unchanged file to keep its timestamp")
+	}
+
+	if !write("a\nc\n") {
+		t.Errorf("expected file with same size and different contents to be changed")
+	}
+	if modTime().Equal(old) {
+		t.Errorf("expected changed file to have a new timestamp")
+	}
+
+	if !write("a\n") {
+		t.Errorf("expected file with different size to be changed")
+	}
+
+	w, err := CreateFileIfChanged(filename, 0666)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write([]byte("aborted"))
+	w.Abort()
+	if data, _ := ioutil.ReadFile(filename); string(data) != "a\n" {
+		t.Errorf("expected aborted write to leave file unchanged, got %q", data)
+	}
+}
@@ -0,0 +1,202 @@
+// Copyright 2020 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blueprint
+
+import (
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+)
+
+// The profiler records the wall time spent in each phase, mutator, singleton and module so that
+// it can be written out in the Chrome trace event format.  Phases, mutators and singletons run
+// one at a time and are recorded on lane 0, where they nest.  Modules may be visited in parallel,
+// so each module event is recorded on the lowest numbered lane that is not in use by another
+// module event at the time, which keeps events on the same lane from overlapping in a trace
+// viewer.
+type profiler struct {
+	start time.Time
+
+	lock   sync.Mutex
+	events []traceEvent
+	lanes  []bool // true for each module lane that is in use
+}
+
+// traceEvent is a single event in the Chrome trace event format.
+type traceEvent struct {
+	Name string                 `json:"name"`
+	Cat  string                 `json:"cat,omitempty"`
+	Ph   string                 `json:"ph"`
+	Ts   int64                  `json:"ts"`
+	Dur  int64                  `json:"dur,omitempty"`
+	Pid  int                    `json:"pid"`
+	Tid  int                    `json:"tid"`
+	Args map[string]interface{} `json:"args,omitempty"`
+}
+
+type profileSpan struct {
+	profiler *profiler
+	name     string
+	cat      string
+	lane     int
+	module   *moduleInfo
+	mutator  string
+	start    time.Time
+}
+
+func newProfiler() *profiler {
+	return &profiler{
+		start: time.Now(),
+	}
+}
+
+// begin starts a span for a phase, mutator or singleton.  It returns nil if p is nil.
+func (p *profiler) begin(name, cat string) *profileSpan {
+	if p == nil {
+		return nil
+	}
+	return &profileSpan{
+		profiler: p,
+		name:     name,
+		cat:      cat,
+		start:    time.Now(),
+	}
+}
+
+// beginModule starts a span for work done on a single module, which may run in parallel with
+// work on other modules.  mutator is the name of the mutator being run on the module, if any.  It
+// returns nil if p is nil.
+func (p *profiler) beginModule(module *moduleInfo, cat, mutator string) *profileSpan {
+	if p == nil {
+		return nil
+	}
+
+	p.lock.Lock()
+	lane := 0
+	for lane < len(p.lanes) && p.lanes[lane] {
+		lane++
+	}
+	if lane == len(p.lanes) {
+		p.lanes = append(p.lanes, true)
+	} else {
+		p.lanes[lane] = true
+	}
+	p.lock.Unlock()
+
+	return &profileSpan{
+		profiler: p,
+		name:     module.Name(),
+		cat:      cat,
+		lane:     lane + 1,
+		module:   module,
+		mutator:  mutator,
+		start:    time.Now(),
+	}
+}
+
+// end finishes the span and records it with args, which may be nil.  It does nothing if s is nil.
+func (s *profileSpan) end(args map[string]interface{}) {
+	if s == nil {
+		return
+	}
+
+	p := s.profiler
+	end := time.Now()
+
+	if s.module != nil {
+		if args == nil {
+			args = make(map[string]interface{})
+		}
+		args["type"] = s.module.typeName
+		if s.module.variantName != "" {
+			args["variant"] = s.module.variantName
+		}
+		if s.mutator != "" {
+			args["mutator"] = s.mutator
+		}
+	}
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	p.events = append(p.events, traceEvent{
+		Name: s.name,
+		Cat:  s.cat,
+		Ph:   "X",
+		Ts:   s.start.Sub(p.start).Microseconds(),
+		Dur:  end.Sub(s.start).Microseconds(),
+		Tid:  s.lane,
+		Args: args,
+	})
+
+	if s.module != nil {
+		p.lanes[s.lane-1] = false
+	}
+}
+
+func (p *profiler) write(w io.Writer) error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	events := make([]traceEvent, 0, len(p.lanes)+1+len(p.events))
+	events = append(events, traceEvent{
+		Name: "thread_name",
+		Ph:   "M",
+		Args: map[string]interface{}{"name": "blueprint"},
+	})
+	for i := range p.lanes {
+		events = append(events, traceEvent{
+			Name: "thread_name",
+			Ph:   "M",
+			Tid:  i + 1,
+			Args: map[string]interface{}{"name": "modules"},
+		})
+	}
+	events = append(events, p.events...)
+
+	return json.NewEncoder(w).Encode(events)
+}
+
+// SetProfiling enables or disables recording the wall time spent in each phase, mutator,
+// singleton and module.  It must be called before ParseBlueprintsFiles to record the whole run.
+// Enabling profiling discards anything that was previously recorded.
+func (c *Context) SetProfiling(profiling bool) {
+	if profiling {
+		c.profiler = newProfiler()
+	} else {
+		c.profiler = nil
+	}
+}
+
+// WriteProfile writes everything recorded since SetProfiling(true) was called to w in the Chrome
+// trace event format, which can be loaded into chrome://tracing or another trace viewer.  Mutator
+// events record the number of modules, including variants, before and after the mutator ran.
+// Module events record the module type and variant.
+func (c *Context) WriteProfile(w io.Writer) error {
+	if c.profiler == nil {
+		return nil
+	}
+	return c.profiler.write(w)
+}
+
+// moduleCount returns the number of modules, including all variants.
+func (c *Context) moduleCount() int {
+	count := 0
+	for _, group := range c.moduleGroups {
+		count += len(group.modules)
+	}
+	return count
+}
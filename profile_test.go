@@ -0,0 +1,124 @@
+// Copyright 2020 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blueprint
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"testing"
+)
+
+type profileTestSingleton struct{}
+
+func (profileTestSingleton) GenerateBuildActions(SingletonContext) {}
+
+func TestProfile(t *testing.T) {
+	ctx := NewContext()
+	ctx.SetProfiling(true)
+	ctx.RegisterModuleType("foo_module", newFooModule)
+	ctx.RegisterBottomUpMutator("deps", depsMutator).Parallel()
+	ctx.RegisterBottomUpMutator("split", func(mctx BottomUpMutatorContext) {
+		mctx.CreateVariations("a", "b")
+	}).Parallel()
+	ctx.RegisterSingletonType("profile_singleton", func() Singleton { return profileTestSingleton{} })
+	ctx.MockFileSystem(map[string][]byte{
+		"Blueprints": []byte(`
+			foo_module {
+				name: "A",
+				deps: ["B"],
+			}
+
+			foo_module {
+				name: "B",
+			}
+		`),
+	})
+
+	_, errs := ctx.ParseBlueprintsFiles("Blueprints", nil)
+	if len(errs) == 0 {
+		_, _, errs = ctx.PrepareBuildActions(nil)
+	}
+	if len(errs) == 0 {
+		if err := ctx.WriteBuildFile(ioutil.Discard); err != nil {
+			errs = []error{err}
+		}
+	}
+	if len(errs) > 0 {
+		t.Errorf("unexpected errors:")
+		for _, err := range errs {
+			t.Errorf("  %s", err)
+		}
+		t.FailNow()
+	}
+
+	buf := &bytes.Buffer{}
+	if err := ctx.WriteProfile(buf); err != nil {
+		t.Fatal(err)
+	}
+
+	var events []traceEvent
+	if err := json.Unmarshal(buf.Bytes(), &events); err != nil {
+		t.Fatalf("failed to parse profile: %s\n%s", err, buf.String())
+	}
+
+	find := func(cat, name string, match func(traceEvent) bool) []traceEvent {
+		var ret []traceEvent
+		for _, e := range events {
+			if e.Ph == "X" && e.Cat == cat && e.Name == name && (match == nil || match(e)) {
+				ret = append(ret, e)
+			}
+		}
+		return ret
+	}
+
+	for _, phase := range []string{"Parse", "ResolveDependencies", "PrepareBuildActions", "WriteBuildFile"} {
+		if len(find("phase", phase, nil)) != 1 {
+			t.Errorf("missing event for phase %s", phase)
+		}
+	}
+
+	if len(find("singleton", "profile_singleton", nil)) != 1 {
+		t.Errorf("missing event for singleton profile_singleton")
+	}
+
+	split := find("mutator", "split", func(e traceEvent) bool { return e.Tid == 0 })
+	if len(split) != 1 {
+		t.Fatalf("expected 1 event for mutator split, got %d", len(split))
+	}
+	if before, after := split[0].Args["modules_before"], split[0].Args["modules_after"]; before != 2.0 || after != 4.0 {
+		t.Errorf("expected split to go from 2 to 4 modules, got %v to %v", before, after)
+	}
+
+	if n := len(find("mutator", "A", func(e traceEvent) bool { return e.Args["mutator"] == "split" })); n != 1 {
+		t.Errorf("expected 1 event for module A in mutator split, got %d", n)
+	}
+
+	for _, variant := range []string{"a", "b"} {
+		generate := find("GenerateBuildActions", "A", func(e traceEvent) bool {
+			return e.Args["variant"] == variant
+		})
+		if len(generate) != 1 {
+			t.Errorf("expected 1 GenerateBuildActions event for variant %q of A, got %d", variant, len(generate))
+			continue
+		}
+		if generate[0].Tid == 0 {
+			t.Errorf("expected module event to be recorded on a module lane")
+		}
+		if generate[0].Args["type"] != "foo_module" {
+			t.Errorf("expected module type foo_module, got %v", generate[0].Args["type"])
+		}
+	}
+}
@@ -0,0 +1,250 @@
+// Copyright 2020 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blueprint
+
+import (
+	"crypto/sha1"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"sync"
+
+	"github.com/google/blueprint/pathtools"
+	"github.com/google/blueprint/proptools"
+)
+
+// NinjaShards configures WriteBuildFile to write the build actions of modules to separate Ninja
+// files that are included from the main Ninja file with subninja statements.  The global
+// variables, pools and rules are still written to the main Ninja file, along with the build
+// actions of singletons.
+type NinjaShards struct {
+	// Dir is the directory the shard files are written to.
+	Dir string
+
+	// NinjaDir is the path of Dir relative to the directory Ninja runs in, which is used in the
+	// subninja statements.  If it is empty Dir is used.
+	NinjaDir string
+
+	// Count is the number of shards that modules are divided between using a hash of their name.
+	// If Count is 0 the modules are instead divided by the directory of the Blueprints file that
+	// defines them, with the shard for each directory written to the same relative path under
+	// Dir.
+	Count int
+}
+
+// SetNinjaShards makes WriteBuildFile write the build actions of modules to shards as described by
+// shards instead of to the main Ninja file, or to the main Ninja file again if shards is nil.  The
+// shards are written in parallel, and a shard file is only replaced if its contents have changed
+// so that its timestamp is unchanged otherwise.  A hash of the contents of each shard is written
+// next to its subninja statement, so that the main Ninja file changes whenever one of its shards
+// does even if it is also only replaced when its contents change.  Any other files in shards.Dir
+// are removed, so it must not be shared with other outputs.
+func (c *Context) SetNinjaShards(shards *NinjaShards) {
+	c.ninjaShards = shards
+}
+
+// A ninjaShard is a file containing the build actions of a set of modules.
+type ninjaShard struct {
+	path    string
+	modules []*moduleInfo
+
+	// hash is the hash of the contents of the shard file, set when it is written.
+	hash []byte
+}
+
+// shardModules divides the modules that have build actions between the shard files, returning the
+// shards sorted by path.  The variants of a module are always in the same shard.
+func (c *Context) shardModules() []*ninjaShard {
+	shards := make(map[string]*ninjaShard)
+	for _, module := range c.modulesSortedByName() {
+		if !module.hasBuildActions() {
+			continue
+		}
+
+		var path string
+		if c.ninjaShards.Count > 0 {
+			h := fnv.New32a()
+			h.Write([]byte(module.Name()))
+			path = fmt.Sprintf("shard%d.ninja", h.Sum32()%uint32(c.ninjaShards.Count))
+		} else {
+			path = filepath.Join(filepath.Dir(module.relBlueprintsFile), "build.ninja")
+		}
+
+		shard := shards[path]
+		if shard == nil {
+			shard = &ninjaShard{path: path}
+			shards[path] = shard
+		}
+		shard.modules = append(shard.modules, module)
+	}
+
+	ret := make([]*ninjaShard, 0, len(shards))
+	for _, shard := range shards {
+		ret = append(ret, shard)
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i].path < ret[j].path })
+	return ret
+}
+
+// writeNinjaShards writes the build actions of the modules to the shard files in parallel, and a
+// subninja statement for each of them to nw preceded by a comment with the hash of its contents.
+func (c *Context) writeNinjaShards(nw *ninjaWriter) error {
+	shards := c.shardModules()
+
+	// Each shard writer holds a buffer of up to ninjaWriterBufferSize, so bound the number of
+	// shards written at once by the number of CPUs rather than the default parallelism.
+	limit := runtime.GOMAXPROCS(0)
+	if c.parallelism > 0 {
+		limit = c.parallelism
+	}
+	sem := make(chan struct{}, limit)
+	errs := make([]error, len(shards))
+
+	var wg sync.WaitGroup
+	for i, shard := range shards {
+		wg.Add(1)
+		go func(i int, shard *ninjaShard) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			errs[i] = c.writeNinjaShard(shard)
+		}(i, shard)
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+
+	err := c.removeStaleShards(shards)
+	if err != nil {
+		return err
+	}
+
+	ninjaDir := c.ninjaShards.NinjaDir
+	if ninjaDir == "" {
+		ninjaDir = c.ninjaShards.Dir
+	}
+
+	for _, shard := range shards {
+		path := filepath.Join(ninjaDir, shard.path)
+		err = nw.Comment(fmt.Sprintf("sha1: %x", shard.hash))
+		if err != nil {
+			return err
+		}
+		err = nw.Subninja(inputEscaper.Replace(proptools.NinjaEscape(path)))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nw.BlankLine()
+}
+
+// writeNinjaShard writes the build actions of the modules in a shard to its file, which is only
+// replaced if its contents changed, and sets the hash of the shard to the hash of its contents.
+func (c *Context) writeNinjaShard(shard *ninjaShard) (err error) {
+	f, err := pathtools.CreateFileIfChanged(filepath.Join(c.ninjaShards.Dir, shard.path), 0666)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			f.Abort()
+		}
+	}()
+
+	h := sha1.New()
+	nw := c.newNinjaWriter(io.MultiWriter(f, h))
+
+	err = nw.Comment("******** Generated by Blueprint and included by the main Ninja file. ********")
+	if err != nil {
+		return err
+	}
+
+	err = nw.BlankLine()
+	if err != nil {
+		return err
+	}
+
+	err = c.writeModuleActions(nw, shard.modules)
+	if err != nil {
+		return err
+	}
+
+	err = nw.Flush()
+	if err != nil {
+		return err
+	}
+
+	shard.hash = h.Sum(nil)
+
+	return f.Close()
+}
+
+// removeStaleShards removes the files in the shard directory that were not written for shards, such
+// as the shards of directories that no longer contain modules, and the directories they leave
+// empty.
+func (c *Context) removeStaleShards(shards []*ninjaShard) error {
+	dir := c.ninjaShards.Dir
+
+	if _, err := os.Stat(dir); os.IsNotExist(err) {
+		return nil
+	}
+
+	keep := make(map[string]bool, len(shards))
+	for _, shard := range shards {
+		keep[filepath.Join(dir, shard.path)] = true
+	}
+
+	var stale, dirs []string
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			if path != dir {
+				dirs = append(dirs, path)
+			}
+		} else if !keep[path] {
+			stale = append(stale, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, path := range stale {
+		err := os.Remove(path)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Walk visits directories before their contents, so remove them in reverse order to remove
+	// nested directories first.  Directories that are not empty can't be removed and are left.
+	for i := len(dirs) - 1; i >= 0; i-- {
+		os.Remove(dirs[i])
+	}
+
+	return nil
+}
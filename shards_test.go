@@ -0,0 +1,249 @@
+// Copyright 2020 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blueprint
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/blueprint/pathtools"
+)
+
+var (
+	shardsPctx = NewPackageContext("github.com/google/blueprint/shards_test")
+
+	shardsTouch = shardsPctx.StaticRule("touch", RuleParams{
+		Command: "touch $out",
+	})
+)
+
+type shardsModule struct {
+	SimpleName
+}
+
+func newShardsModule() (Module, []interface{}) {
+	m := &shardsModule{}
+	return m, []interface{}{&m.SimpleName.Properties}
+}
+
+func (m *shardsModule) GenerateBuildActions(ctx ModuleContext) {
+	ctx.Build(shardsPctx, BuildParams{
+		Rule:    shardsTouch,
+		Outputs: []string{ctx.ModuleName()},
+	})
+}
+
+var shardsTestFiles = map[string][]byte{
+	"Blueprints":     []byte(`test { name: "root" }`),
+	"a/Blueprints":   []byte(`test { name: "a1" } test { name: "a2" }`),
+	"a/b/Blueprints": []byte(`test { name: "b" }`),
+}
+
+func runShardsTest(t *testing.T, shards *NinjaShards, files map[string][]byte) string {
+	t.Helper()
+
+	ctx := NewContext()
+	ctx.RegisterModuleType("test", newShardsModule)
+	ctx.SetNinjaShards(shards)
+	ctx.MockFileSystem(files)
+
+	_, errs := ctx.ParseBlueprintsFiles("Blueprints", nil)
+	if len(errs) == 0 {
+		_, errs = ctx.ResolveDependencies(nil)
+	}
+	if len(errs) == 0 {
+		_, _, errs = ctx.PrepareBuildActions(nil)
+	}
+	if len(errs) > 0 {
+		t.Fatalf("unexpected errors: %q", errs)
+	}
+
+	buf := &bytes.Buffer{}
+	if err := ctx.WriteBuildFile(buf); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	return buf.String()
+}
+
+func readShard(t *testing.T, path string) string {
+	t.Helper()
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	return string(data)
+}
+
+func TestNinjaShards(t *testing.T) {
+	dir, err := ioutil.TempDir("", "shards")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	t.Run("by directory", func(t *testing.T) {
+		shardDir := filepath.Join(dir, "by_directory")
+		out := runShardsTest(t, &NinjaShards{Dir: shardDir, NinjaDir: "shards"}, shardsTestFiles)
+
+		var subninjas []string
+		for _, line := range strings.Split(out, "\n") {
+			if strings.HasPrefix(line, "subninja ") {
+				subninjas = append(subninjas, line)
+			}
+		}
+		want := []string{
+			"subninja shards/a/b/build.ninja",
+			"subninja shards/a/build.ninja",
+			"subninja shards/build.ninja",
+		}
+		if !reflect.DeepEqual(subninjas, want) {
+			t.Errorf("expected subninja statements %q, got %q", want, subninjas)
+		}
+		if i, j := strings.Index(out, "rule g.shards_test.touch"), strings.Index(out, "subninja"); i == -1 || i > j {
+			t.Errorf("expected rules before subninja statements, got:\n%s", out)
+		}
+		if strings.Contains(out, "build root:") {
+			t.Errorf("expected module build statements to be in shards, got:\n%s", out)
+		}
+
+		for path, outputs := range map[string][]string{
+			"build.ninja":     {"root"},
+			"a/build.ninja":   {"a1", "a2"},
+			"a/b/build.ninja": {"b"},
+		} {
+			shard := readShard(t, filepath.Join(shardDir, path))
+			for _, output := range outputs {
+				want := "build " + output + ": g.shards_test.touch\n"
+				if !strings.Contains(shard, want) {
+					t.Errorf("expected %s to contain %q, got:\n%s", path, want, shard)
+				}
+			}
+		}
+	})
+
+	t.Run("by hash", func(t *testing.T) {
+		shardDir := filepath.Join(dir, "by_hash")
+		out := runShardsTest(t, &NinjaShards{Dir: shardDir, Count: 2}, shardsTestFiles)
+
+		files, err := ioutil.ReadDir(shardDir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(files) == 0 || len(files) > 2 {
+			t.Fatalf("expected 1 or 2 shards, got %d", len(files))
+		}
+
+		all := ""
+		for _, file := range files {
+			if !strings.Contains(out, "subninja "+filepath.Join(shardDir, file.Name())+"\n") {
+				t.Errorf("missing subninja statement for %s in:\n%s", file.Name(), out)
+			}
+			all += readShard(t, filepath.Join(shardDir, file.Name()))
+		}
+		for _, output := range []string{"root", "a1", "a2", "b"} {
+			if n := strings.Count(all, "build "+output+":"); n != 1 {
+				t.Errorf("expected one build statement for %q in shards, got %d", output, n)
+			}
+		}
+	})
+
+	t.Run("stale", func(t *testing.T) {
+		shardDir := filepath.Join(dir, "stale")
+		runShardsTest(t, &NinjaShards{Dir: shardDir}, shardsTestFiles)
+
+		stray := filepath.Join(shardDir, "a", "stray.ninja")
+		if err := ioutil.WriteFile(stray, nil, 0666); err != nil {
+			t.Fatal(err)
+		}
+
+		runShardsTest(t, &NinjaShards{Dir: shardDir, Count: 1}, shardsTestFiles)
+
+		files, err := ioutil.ReadDir(shardDir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var names []string
+		for _, file := range files {
+			names = append(names, file.Name())
+		}
+		if want := []string{"shard0.ninja"}; !reflect.DeepEqual(names, want) {
+			t.Errorf("expected stale shards and their directories to be removed, leaving %q, got %q",
+				want, names)
+		}
+	})
+
+	t.Run("unchanged", func(t *testing.T) {
+		shardDir := filepath.Join(dir, "unchanged")
+		runShardsTest(t, &NinjaShards{Dir: shardDir}, shardsTestFiles)
+
+		path := filepath.Join(shardDir, "build.ninja")
+		old := time.Now().Add(-time.Hour).Truncate(time.Second)
+		if err := os.Chtimes(path, old, old); err != nil {
+			t.Fatal(err)
+		}
+
+		runShardsTest(t, &NinjaShards{Dir: shardDir}, shardsTestFiles)
+
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !info.ModTime().Equal(old) {
+			t.Errorf("expected unchanged shard to keep its timestamp %s, got %s", old, info.ModTime())
+		}
+	})
+
+	t.Run("shard changed", func(t *testing.T) {
+		shardDir := filepath.Join(dir, "shard_changed")
+		mainFile := filepath.Join(dir, "shard_changed.ninja")
+
+		writeMain := func(files map[string][]byte) bool {
+			t.Helper()
+			f, err := pathtools.CreateFileIfChanged(mainFile, 0666)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := f.Write([]byte(runShardsTest(t, &NinjaShards{Dir: shardDir}, files))); err != nil {
+				f.Abort()
+				t.Fatal(err)
+			}
+			if err := f.Close(); err != nil {
+				t.Fatal(err)
+			}
+			return f.Changed()
+		}
+
+		writeMain(shardsTestFiles)
+		if writeMain(shardsTestFiles) {
+			t.Errorf("expected main Ninja file to be unchanged when no shard changed")
+		}
+
+		// Renaming a module only changes the build statements in its shard.
+		files := make(map[string][]byte)
+		for path, contents := range shardsTestFiles {
+			files[path] = contents
+		}
+		files["a/b/Blueprints"] = []byte(`test { name: "c" }`)
+		if !writeMain(files) {
+			t.Errorf("expected main Ninja file to change when only a shard changed")
+		}
+	})
+}
@@ -53,6 +53,14 @@ type SingletonContext interface {
 	// Errorf reports an error at the specified position of the module definition file.
 	Errorf(format string, args ...interface{})
 
+	// ModuleWarningf reports a warning in category at the line number of the module type in the
+	// module definition.  Warnings do not cause the build to fail, see Context.Warnings.
+	ModuleWarningf(module Module, category, format string, args ...interface{})
+
+	// PropertyWarningf reports a warning in category at the line number of a property in the module
+	// definition.  Warnings do not cause the build to fail, see Context.Warnings.
+	PropertyWarningf(module Module, property, category, format string, args ...interface{})
+
 	// Failed returns true if any errors have been reported.  In most cases the singleton can continue with generating
 	// build rules after an error, allowing it to report additional errors in a single run, but in cases where the error
 	// has prevented the singleton from creating necessary data it can return early when Failed returns true.
@@ -81,6 +89,12 @@ type SingletonContext interface {
 	// only ever be used inside bootstrap to handle glob rules.
 	AddSubninja(file string)
 
+	// AddDefaultTargets adds paths to the targets that Ninja builds when it is run without any
+	// targets on the command line.  The paths are not evaluated as ninja strings.  Ninja only
+	// builds the default targets when there are any, so the outputs of build statements that are
+	// not Optional are always added to the default targets.
+	AddDefaultTargets(targets ...string)
+
 	// Eval takes a string with embedded ninja variables, and returns a string
 	// with all of the variables recursively expanded. Any variables references
 	// are expanded in the scope of the PackageContext.
@@ -160,6 +174,7 @@ type singletonContext struct {
 
 	ninjaFileDeps []string
 	errs          []error
+	warnings      []*Warning
 
 	actionDefs localBuildActions
 }
@@ -209,6 +224,20 @@ func (s *singletonContext) Errorf(format string, args ...interface{}) {
 	s.error(fmt.Errorf(format, args...))
 }
 
+func (s *singletonContext) ModuleWarningf(logicModule Module, category, format string,
+	args ...interface{}) {
+
+	module := s.context.moduleInfo[logicModule]
+	s.warnings = append(s.warnings, newModuleWarning(module, category, format, args))
+}
+
+func (s *singletonContext) PropertyWarningf(logicModule Module, property, category, format string,
+	args ...interface{}) {
+
+	module := s.context.moduleInfo[logicModule]
+	s.warnings = append(s.warnings, newPropertyWarning(module, property, category, format, args))
+}
+
 func (s *singletonContext) Failed() bool {
 	return len(s.errs) > 0
 }
@@ -247,6 +276,10 @@ func (s *singletonContext) Build(pctx PackageContext, params BuildParams) {
 		panic(err)
 	}
 
+	if s.context.buildLocationComments {
+		def.Caller = buildCaller()
+	}
+
 	s.actionDefs.buildDefs = append(s.actionDefs.buildDefs, def)
 }
 
@@ -285,6 +318,10 @@ func (s *singletonContext) AddSubninja(file string) {
 	s.context.subninjas = append(s.context.subninjas, file)
 }
 
+func (s *singletonContext) AddDefaultTargets(targets ...string) {
+	s.context.addDefaultTargets(targets)
+}
+
 func (s *singletonContext) VisitAllModules(visit func(Module)) {
 	var visitingModule Module
 	defer func() {
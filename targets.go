@@ -0,0 +1,158 @@
+// Copyright 2020 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blueprint
+
+import (
+	"path/filepath"
+	"sort"
+
+	"github.com/google/blueprint/proptools"
+)
+
+// SetDirectoryTargets sets whether WriteBuildFile generates a phony target for each directory that
+// contains a Blueprints file, or is a parent of one, named after the path of the directory.  The
+// target depends on the outputs of every build statement of every module defined in the directory
+// and its subdirectories, so that "ninja path/to/dir" builds all of them.  No target is generated
+// for the top level directory, or for a directory whose path is also the path of an output of a
+// build statement.
+func (c *Context) SetDirectoryTargets(directoryTargets bool) {
+	c.directoryTargets = directoryTargets
+}
+
+func (c *Context) addDefaultTargets(targets []string) {
+	c.defaultTargets = append(c.defaultTargets, targets...)
+}
+
+// writeDefaultTargets writes a default statement for the targets passed to
+// SingletonContext.AddDefaultTargets.
+func (c *Context) writeDefaultTargets(nw *ninjaWriter) error {
+	if len(c.defaultTargets) == 0 {
+		return nil
+	}
+
+	targets := make([]string, len(c.defaultTargets))
+	for i, target := range c.defaultTargets {
+		targets[i] = inputEscaper.Replace(proptools.NinjaEscape(target))
+	}
+
+	err := nw.Comment("Default targets")
+	if err != nil {
+		return err
+	}
+
+	err = nw.Default(targets...)
+	if err != nil {
+		return err
+	}
+
+	return nw.BlankLine()
+}
+
+// writeDirectoryTargets writes the phony targets for each directory if they were enabled with
+// SetDirectoryTargets.
+func (c *Context) writeDirectoryTargets(nw *ninjaWriter) error {
+	if !c.directoryTargets {
+		return nil
+	}
+
+	// Collect the paths of every output so that directory targets that would conflict with them
+	// can be skipped.
+	outputs := make(map[string]bool)
+	addOutputs := func(actions *localBuildActions) {
+		for _, def := range actions.buildDefs {
+			for _, output := range def.Outputs {
+				outputs[output.ValueWithEscaper(c.pkgNames, inputEscaper)] = true
+			}
+			for _, output := range def.ImplicitOutputs {
+				outputs[output.ValueWithEscaper(c.pkgNames, inputEscaper)] = true
+			}
+		}
+	}
+	for _, info := range c.singletonInfo {
+		addOutputs(&info.actionDefs)
+	}
+
+	modules := c.modulesSortedByName()
+	for _, module := range modules {
+		addOutputs(&module.actionDefs)
+	}
+
+	dirOutputs := make(map[string][]string)
+	subdirs := make(map[string][]string)
+	seen := make(map[string]bool)
+	for _, module := range modules {
+		dir := filepath.Dir(module.relBlueprintsFile)
+		for _, def := range module.actionDefs.buildDefs {
+			dirOutputs[dir] = append(dirOutputs[dir],
+				valueList(def.Outputs, c.pkgNames, inputEscaper)...)
+		}
+
+		// Add the directory and any parent directories that have not been seen yet to their
+		// parents.
+		for dir != "." && !seen[dir] {
+			seen[dir] = true
+			parent := filepath.Dir(dir)
+			subdirs[parent] = append(subdirs[parent], dir)
+			dir = parent
+		}
+	}
+
+	var dirs []string
+	for dir := range seen {
+		dirs = append(dirs, dir)
+	}
+	sort.Strings(dirs)
+
+	// inputs returns the inputs of the phony target for a directory, which are the outputs of
+	// the modules in the directory and the targets for its subdirectories, or the inputs of a
+	// subdirectory if it doesn't have a target.
+	var inputs func(dir string) []string
+	inputs = func(dir string) []string {
+		ret := append([]string(nil), dirOutputs[dir]...)
+		children := subdirs[dir]
+		sort.Strings(children)
+		for _, child := range children {
+			if target := inputEscaper.Replace(proptools.NinjaEscape(child)); outputs[target] {
+				ret = append(ret, inputs(child)...)
+			} else {
+				ret = append(ret, target)
+			}
+		}
+		return ret
+	}
+
+	wroteComment := false
+	for _, dir := range dirs {
+		if outputs[inputEscaper.Replace(proptools.NinjaEscape(dir))] {
+			continue
+		}
+
+		if !wroteComment {
+			err := nw.Comment("Directory targets")
+			if err != nil {
+				return err
+			}
+			wroteComment = true
+		}
+
+		target := outputEscaper.Replace(proptools.NinjaEscape(dir))
+		err := nw.Build("", "phony", []string{target}, nil, inputs(dir), nil, nil, nil, "")
+		if err != nil {
+			return err
+		}
+	}
+
+	return nw.BlankLine()
+}
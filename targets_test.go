@@ -0,0 +1,113 @@
+// Copyright 2020 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blueprint
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+var (
+	targetsPctx = NewPackageContext("github.com/google/blueprint/targets_test")
+
+	targetsTouch = targetsPctx.StaticRule("touch", RuleParams{
+		Command: "touch $out",
+	})
+)
+
+type targetsModule struct {
+	SimpleName
+	properties struct {
+		Outs []string
+	}
+}
+
+func newTargetsModule() (Module, []interface{}) {
+	m := &targetsModule{}
+	return m, []interface{}{&m.properties, &m.SimpleName.Properties}
+}
+
+func (m *targetsModule) GenerateBuildActions(ctx ModuleContext) {
+	for _, out := range m.properties.Outs {
+		ctx.Build(targetsPctx, BuildParams{
+			Rule:     targetsTouch,
+			Outputs:  []string{out},
+			Optional: true,
+		})
+	}
+}
+
+type defaultTargetsSingleton struct{}
+
+func (s *defaultTargetsSingleton) GenerateBuildActions(ctx SingletonContext) {
+	ctx.AddDefaultTargets("a/a1", "with space")
+}
+
+func TestTargets(t *testing.T) {
+	ctx := NewContext()
+	ctx.SetDirectoryTargets(true)
+	ctx.RegisterModuleType("test", newTargetsModule)
+	ctx.RegisterSingletonType("default_targets", func() Singleton { return &defaultTargetsSingleton{} })
+	ctx.MockFileSystem(map[string][]byte{
+		"Blueprints":         []byte(`test { name: "root", outs: ["root_out", "x"] }`),
+		"a/Blueprints":       []byte(`test { name: "a", outs: ["a/a1", "a/a2"] }`),
+		"a/b/c/Blueprints":   []byte(`test { name: "c", outs: ["a/b/c/c1"] }`),
+		"x/Blueprints":       []byte(`test { name: "x", outs: ["x/x1"] }`),
+		"x/y/Blueprints":     []byte(`test { name: "y", outs: ["x/y/y1"] }`),
+		"empty/Blueprints":   []byte(`test { name: "empty" }`),
+		"other/d/Blueprints": []byte(`test { name: "d", outs: ["d1"] }`),
+	})
+
+	_, errs := ctx.ParseBlueprintsFiles("Blueprints", nil)
+	if len(errs) == 0 {
+		_, errs = ctx.ResolveDependencies(nil)
+	}
+	if len(errs) == 0 {
+		// Default targets left from an earlier run are discarded by PrepareBuildActions.
+		ctx.addDefaultTargets([]string{"stale"})
+		_, _, errs = ctx.PrepareBuildActions(nil)
+	}
+	if len(errs) > 0 {
+		t.Fatalf("unexpected errors: %q", errs)
+	}
+
+	buf := &bytes.Buffer{}
+	if err := ctx.WriteBuildFile(buf); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	i := strings.Index(buf.String(), "# Directory targets")
+	if i == -1 {
+		t.Fatalf("missing directory targets in:\n%s", buf.String())
+	}
+
+	want := `# Directory targets
+build a: phony a/a1 a/a2 a/b
+build a/b: phony a/b/c
+build a/b/c: phony a/b/c/c1
+build empty: phony
+build other: phony other/d
+build other/d: phony d1
+build x/y: phony x/y/y1
+
+# Default targets
+default a/a1 with$ space
+
+`
+	if got := buf.String()[i:]; got != want {
+		t.Errorf("incorrect targets, expected:\n%s\ngot:\n%s", want, got)
+	}
+}
@@ -0,0 +1,245 @@
+// Copyright 2020 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blueprint
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// A TransitionMutator declaratively describes how modules are split into variants and which
+// variant of each dependency is used, instead of calling CreateVariations and
+// SetDependencyVariation from a BottomUpMutator.
+//
+// For every module Context computes a list of variations.  The list starts with the variations
+// returned by Split, followed in sorted order by any other variations requested by the modules that
+// depend on it.  If Split returned [""] and any variations were requested, the list only contains
+// the requested variations.  For every variation of a depending module and every dependency of that module,
+// OutgoingTransition is called with the variation of the depending module to get the variation it
+// wants for the dependency, and IncomingTransition is called with that variation to get the
+// variation of the dependency that will actually be used.
+//
+// A module whose list of variations is [""] is not split.  Otherwise Context creates a variant of
+// the module for each variation and wires each dependency to the variant selected by the
+// transition.  Mutate is then called on every module with its variation, or "" if it was not
+// split.
+//
+// The methods of a TransitionMutator may be called in parallel on different modules.
+type TransitionMutator interface {
+	// Split returns the variations a module should be split into, independent of the variations
+	// requested by modules that depend on it.  Returning [""] leaves the module unsplit unless a
+	// depending module requests a variation.
+	Split(ctx BaseModuleContext) []string
+
+	// OutgoingTransition returns the variation of the dependency described by ctx that a variant of
+	// the depending module with sourceVariation wants.
+	OutgoingTransition(ctx OutgoingTransitionContext, sourceVariation string) string
+
+	// IncomingTransition returns the variation of the dependency described by ctx that will be used
+	// when incomingVariation is requested by a depending module.
+	IncomingTransition(ctx IncomingTransitionContext, incomingVariation string) string
+
+	// Mutate is called on each variant of a module after it has been created, with the variation it
+	// was created for.  It is called with "" on modules that were not split.
+	Mutate(ctx BottomUpMutatorContext, variation string)
+}
+
+type transitionContext interface {
+	// DepTag returns the DependencyTag of the dependency being transitioned.
+	DepTag() DependencyTag
+
+	// Config returns the config object that was passed to Context.ResolveDependencies.
+	Config() interface{}
+}
+
+// OutgoingTransitionContext describes a dependency edge from the point of view of the depending
+// module.
+type OutgoingTransitionContext interface {
+	transitionContext
+
+	// Module returns the depending module.
+	Module() Module
+}
+
+// IncomingTransitionContext describes a dependency edge from the point of view of the dependency.
+type IncomingTransitionContext interface {
+	transitionContext
+
+	// Module returns the dependency.
+	Module() Module
+}
+
+type transitionContextImpl struct {
+	module *moduleInfo
+	depTag DependencyTag
+	config interface{}
+}
+
+func (t *transitionContextImpl) Module() Module {
+	return t.module.logicModule
+}
+
+func (t *transitionContextImpl) DepTag() DependencyTag {
+	return t.depTag
+}
+
+func (t *transitionContextImpl) Config() interface{} {
+	return t.config
+}
+
+type transitionMutatorImpl struct {
+	name    string
+	mutator TransitionMutator
+
+	// variations holds the variations required of each module, protected by lock.  Modules that
+	// depend on a module add to its list, and the module itself takes it over once all of them
+	// have been visited by the top down pass.
+	lock       sync.Mutex
+	variations map[*moduleInfo][]string
+}
+
+// RegisterTransitionMutator registers a TransitionMutator that splits modules into variations
+// named name.  It is implemented by registering a parallel TopDownMutator called name+"_propagate"
+// that computes the variations required of each module, a parallel BottomUpMutator called name that
+// creates the variants, and a parallel BottomUpMutator called name+"_mutate" that calls
+// TransitionMutator.Mutate on each of them.  The three mutators always run one after another, so
+// ordering constraints on any of them apply to all of them, and constraints that would put another
+// mutator between them are reported as conflicts.
+func (c *Context) RegisterTransitionMutator(name string, mutator TransitionMutator) {
+	impl := &transitionMutatorImpl{
+		name:       name,
+		mutator:    mutator,
+		variations: make(map[*moduleInfo][]string),
+	}
+
+	// The three mutators are sorted as a unit, so another mutator can't run between them and
+	// change the dependencies that the variations were computed from.
+	propagate := c.RegisterTopDownMutator(name+"_propagate", impl.propagateMutator).Parallel()
+	split := c.RegisterBottomUpMutator(name, impl.splitMutator).Parallel()
+	mutate := c.RegisterBottomUpMutator(name+"_mutate", impl.mutateMutator).Parallel()
+	split.(*mutatorInfo).unit = propagate.(*mutatorInfo)
+	mutate.(*mutatorInfo).unit = propagate.(*mutatorInfo)
+}
+
+// transition returns the variation of dep that will be used by the sourceVariation variant of
+// module.
+func (t *transitionMutatorImpl) transition(config interface{}, module *moduleInfo,
+	sourceVariation string, dep depInfo) string {
+
+	outgoing := t.mutator.OutgoingTransition(&transitionContextImpl{
+		module: module,
+		depTag: dep.tag,
+		config: config,
+	}, sourceVariation)
+
+	depModule := dep.module
+	if depModule.logicModule == nil {
+		// The dependency has already been split, the first variant holds its original logic module.
+		depModule = depModule.splitModules[0]
+	}
+
+	return t.mutator.IncomingTransition(&transitionContextImpl{
+		module: depModule,
+		depTag: dep.tag,
+		config: config,
+	}, outgoing)
+}
+
+func (t *transitionMutatorImpl) addVariation(module *moduleInfo, variation string) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	if !inList(variation, t.variations[module]) {
+		t.variations[module] = append(t.variations[module], variation)
+	}
+}
+
+func (t *transitionMutatorImpl) propagateMutator(mctx TopDownMutatorContext) {
+	ctx := mctx.(*mutatorContext)
+	module := ctx.module
+
+	variations := t.mutator.Split(mctx)
+	if len(variations) == 0 {
+		panic(fmt.Errorf("transition mutator %q returned no variations for module %q",
+			t.name, module.Name()))
+	}
+	variations = append([]string(nil), variations...)
+
+	// Every module that depends on this one has already been visited, so the list of requested
+	// variations is complete.
+	t.lock.Lock()
+	requested := t.variations[module]
+	t.lock.Unlock()
+
+	requested = append([]string(nil), requested...)
+	sort.Strings(requested)
+	if len(variations) == 1 && variations[0] == "" && len(requested) > 0 {
+		// The module has no variations of its own, only create the ones that were requested.
+		variations = nil
+	}
+	for _, variation := range requested {
+		if !inList(variation, variations) {
+			variations = append(variations, variation)
+		}
+	}
+
+	t.lock.Lock()
+	t.variations[module] = variations
+	t.lock.Unlock()
+
+	for _, variation := range variations {
+		for _, dep := range module.directDeps {
+			t.addVariation(dep.module, t.transition(ctx.config, module, variation, dep))
+		}
+	}
+}
+
+func (t *transitionMutatorImpl) splitMutator(mctx BottomUpMutatorContext) {
+	ctx := mctx.(*mutatorContext)
+
+	t.lock.Lock()
+	variations := t.variations[ctx.module]
+	delete(t.variations, ctx.module)
+	t.lock.Unlock()
+
+	depChooser := func(module *moduleInfo, dep depInfo) (*moduleInfo, string) {
+		variation := t.transition(ctx.config, module, module.variant[t.name], dep)
+		return chooseDep(dep.module.splitModules, t.name, variation, nil)
+	}
+
+	if len(variations) == 0 || (len(variations) == 1 && variations[0] == "") {
+		// The module is not split, but its dependencies may have been.
+		errs := ctx.context.convertDepsToVariation(ctx.module, depChooser)
+		ctx.errs = append(ctx.errs, errs...)
+		return
+	}
+
+	ctx.createVariations(variations, depChooser, false)
+}
+
+func (t *transitionMutatorImpl) mutateMutator(mctx BottomUpMutatorContext) {
+	module := mctx.(*mutatorContext).module
+	t.mutator.Mutate(mctx, module.variant[t.name])
+}
+
+func inList(s string, list []string) bool {
+	for _, l := range list {
+		if l == s {
+			return true
+		}
+	}
+	return false
+}
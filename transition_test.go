@@ -0,0 +1,185 @@
+// Copyright 2020 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blueprint
+
+import (
+	"reflect"
+	"testing"
+)
+
+type transitionTestModule struct {
+	SimpleName
+	properties struct {
+		Arches    []string
+		Host_only bool
+		Deps      []string
+		Host_deps []string
+
+		Mutated_variation *string `blueprint:"mutated"`
+	}
+}
+
+func newTransitionTestModule() (Module, []interface{}) {
+	m := &transitionTestModule{}
+	return m, []interface{}{&m.properties, &m.SimpleName.Properties}
+}
+
+func (m *transitionTestModule) GenerateBuildActions(ModuleContext) {
+}
+
+type transitionTestDepTag struct {
+	BaseDependencyTag
+	host bool
+}
+
+var (
+	transitionTestDeps     = transitionTestDepTag{}
+	transitionTestHostDeps = transitionTestDepTag{host: true}
+)
+
+func transitionTestDepsMutator(ctx BottomUpMutatorContext) {
+	if m, ok := ctx.Module().(*transitionTestModule); ok {
+		ctx.AddDependency(ctx.Module(), transitionTestDeps, m.properties.Deps...)
+		ctx.AddDependency(ctx.Module(), transitionTestHostDeps, m.properties.Host_deps...)
+	}
+}
+
+type transitionTestMutator struct{}
+
+func (transitionTestMutator) Split(ctx BaseModuleContext) []string {
+	if m := ctx.Module().(*transitionTestModule); len(m.properties.Arches) > 0 {
+		return m.properties.Arches
+	}
+	return []string{""}
+}
+
+func (transitionTestMutator) OutgoingTransition(ctx OutgoingTransitionContext,
+	sourceVariation string) string {
+
+	if ctx.DepTag().(transitionTestDepTag).host {
+		return "host"
+	}
+	return sourceVariation
+}
+
+func (transitionTestMutator) IncomingTransition(ctx IncomingTransitionContext,
+	incomingVariation string) string {
+
+	if ctx.Module().(*transitionTestModule).properties.Host_only {
+		return "host"
+	}
+	return incomingVariation
+}
+
+func (transitionTestMutator) Mutate(ctx BottomUpMutatorContext, variation string) {
+	ctx.Module().(*transitionTestModule).properties.Mutated_variation = &variation
+}
+
+func TestTransitionMutator(t *testing.T) {
+	ctx := NewContext()
+	ctx.RegisterModuleType("test", newTransitionTestModule)
+	ctx.RegisterBottomUpMutator("deps", transitionTestDepsMutator)
+	ctx.RegisterTransitionMutator("arch", transitionTestMutator{})
+	ctx.MockFileSystem(map[string][]byte{
+		"Blueprints": []byte(`
+			test {
+				name: "A",
+				arches: ["arm", "x86"],
+				deps: ["B"],
+				host_deps: ["C"],
+			}
+
+			test {
+				name: "B",
+				deps: ["C", "D"],
+			}
+
+			test {
+				name: "C",
+				host_only: true,
+			}
+
+			test {
+				name: "D",
+				arches: ["x86"],
+			}
+
+			test {
+				name: "E",
+			}
+		`),
+	})
+
+	_, errs := ctx.ParseBlueprintsFiles("Blueprints", nil)
+	if len(errs) == 0 {
+		_, errs = ctx.ResolveDependencies(nil)
+	}
+	if len(errs) > 0 {
+		t.Errorf("unexpected errors:")
+		for _, err := range errs {
+			t.Errorf("  %s", err)
+		}
+		t.FailNow()
+	}
+
+	variants := func(name string) []string {
+		var ret []string
+		for _, m := range ctx.moduleGroupFromName(name, nil).modules {
+			ret = append(ret, m.variantName)
+			if got := *m.logicModule.(*transitionTestModule).properties.Mutated_variation; got != m.variant["arch"] {
+				t.Errorf("expected Mutate to be called on %s with %q, got %q", m, m.variant["arch"], got)
+			}
+		}
+		return ret
+	}
+
+	checkVariants := func(name string, want []string) {
+		t.Helper()
+		if got := variants(name); !reflect.DeepEqual(got, want) {
+			t.Errorf("expected variants of %s to be %q, got %q", name, want, got)
+		}
+	}
+
+	checkVariants("A", []string{"arm", "x86"})
+	checkVariants("B", []string{"arm", "x86"})
+	checkVariants("C", []string{"host"})
+	checkVariants("D", []string{"x86", "arm"})
+	checkVariants("E", []string{""})
+
+	checkDeps := func(name, variant string, want ...string) {
+		t.Helper()
+		var module *moduleInfo
+		for _, m := range ctx.moduleGroupFromName(name, nil).modules {
+			if m.variantName == variant {
+				module = m
+			}
+		}
+		if module == nil {
+			t.Fatalf("missing variant %q of %s", variant, name)
+		}
+		var got []string
+		for _, dep := range module.directDeps {
+			got = append(got, dep.module.Name()+":"+dep.module.variantName)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("expected deps of %s to be %q, got %q", module, want, got)
+		}
+	}
+
+	checkDeps("A", "arm", "B:arm", "C:host")
+	checkDeps("A", "x86", "B:x86", "C:host")
+	checkDeps("B", "arm", "C:host", "D:arm")
+	checkDeps("B", "x86", "C:host", "D:x86")
+}
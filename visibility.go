@@ -0,0 +1,277 @@
+// Copyright 2020 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blueprint
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"text/scanner"
+)
+
+// Visibility rules restrict which packages may depend on a module.  A package is the directory
+// containing a Blueprints file, relative to the root directory.  The supported rules are:
+//
+//   "//visibility:public"         any package may depend on the module.
+//   "//visibility:private"        only modules in the same package may depend on the module.
+//   "//some/dir:__pkg__"          modules in some/dir may depend on the module.
+//   "//some/dir:__subpackages__"  modules in some/dir or any package below it may depend on the
+//                                 module.
+//   ":__pkg__", ":__subpackages__"
+//                                 the same as above, relative to the package of the module.
+//
+// The public and private rules may not be combined with other rules.
+//
+// Rules for a single module are provided by implementing VisibilityModule.  Rules for every module
+// in a package that does not provide its own can be set by assigning to the default_visibility
+// variable in the package's Blueprints file:
+//
+//   default_visibility = ["//visibility:private"]
+//
+// The default visibility of a package also applies to any package below it that does not set its
+// own.  Modules with no rules and no default visibility are public.
+//
+// Context checks every dependency once all mutators have run, and reports a ModuleError on the
+// depending module for each dependency that is not visible to it.
+
+const (
+	visibilityPublic  = "//visibility:public"
+	visibilityPrivate = "//visibility:private"
+
+	defaultVisibilityVariable = "default_visibility"
+)
+
+// A VisibilityModule is a Module that restricts which packages may depend on it.
+type VisibilityModule interface {
+	Module
+
+	// Visibility returns the visibility rules for the module, or nil to use the default visibility
+	// of the package that contains it.
+	Visibility() []string
+}
+
+// SimpleVisibility is an embeddable object to implement VisibilityModule using a property called
+// "visibility".  Modules that embed it must also add SimpleVisibility.Properties to their property
+// structure list.
+type SimpleVisibility struct {
+	Properties struct {
+		Visibility []string
+	}
+}
+
+func (s *SimpleVisibility) Visibility() []string {
+	return s.Properties.Visibility
+}
+
+// packageVisibility stores the default_visibility assignment from a Blueprints file.
+type packageVisibility struct {
+	rules []string
+	pos   scanner.Position
+}
+
+type visibilityRule struct {
+	public      bool   // matches every package
+	pkg         string // the package matched by the rule
+	subpackages bool   // also matches every package below pkg
+}
+
+func (r visibilityRule) matches(pkg string) bool {
+	if r.public || r.pkg == pkg {
+		return true
+	}
+	if r.subpackages {
+		return r.pkg == "." || strings.HasPrefix(pkg, r.pkg+"/")
+	}
+	return false
+}
+
+// parseVisibilityRules parses the visibility rules declared by a module or package in pkg.
+func parseVisibilityRules(rules []string, pkg string) ([]visibilityRule, error) {
+	ret := make([]visibilityRule, 0, len(rules))
+	for _, rule := range rules {
+		switch rule {
+		case visibilityPublic, visibilityPrivate:
+			if len(rules) > 1 {
+				return nil, fmt.Errorf("visibility rule %q may not be combined with other visibility rules",
+					rule)
+			}
+			ret = append(ret, visibilityRule{public: rule == visibilityPublic, pkg: pkg})
+			continue
+		}
+
+		colon := strings.LastIndex(rule, ":")
+		if colon == -1 {
+			return nil, fmt.Errorf("invalid visibility rule %q, expected //<package>:<target>", rule)
+		}
+
+		rulePkg := rule[:colon]
+		switch {
+		case rulePkg == "":
+			rulePkg = pkg
+		case strings.HasPrefix(rulePkg, "//"):
+			rulePkg = filepath.Clean(strings.TrimPrefix(rulePkg, "//"))
+			if rulePkg == "visibility" {
+				return nil, fmt.Errorf("unknown visibility rule %q", rule)
+			}
+		default:
+			return nil, fmt.Errorf("invalid visibility rule %q, package must start with //", rule)
+		}
+
+		switch rule[colon+1:] {
+		case "__pkg__":
+			ret = append(ret, visibilityRule{pkg: rulePkg})
+		case "__subpackages__":
+			ret = append(ret, visibilityRule{pkg: rulePkg, subpackages: true})
+		default:
+			return nil, fmt.Errorf("invalid visibility rule %q, target must be __pkg__ or __subpackages__",
+				rule)
+		}
+	}
+
+	return ret, nil
+}
+
+func (module *moduleInfo) pkg() string {
+	return filepath.Dir(module.relBlueprintsFile)
+}
+
+func (c *Context) setPackageVisibility(pkg string, rules []string, pos scanner.Position) {
+	c.visibilityLock.Lock()
+	defer c.visibilityLock.Unlock()
+
+	if c.packageVisibility == nil {
+		c.packageVisibility = make(map[string]*packageVisibility)
+	}
+	c.packageVisibility[pkg] = &packageVisibility{rules, pos}
+}
+
+// defaultVisibility returns the default_visibility assignment of the closest package at or above
+// pkg, or nil if there is none.
+func (c *Context) defaultVisibility(pkg string) *packageVisibility {
+	for {
+		if v, ok := c.packageVisibility[pkg]; ok {
+			return v
+		}
+		if pkg == "." || pkg == "/" {
+			return nil
+		}
+		pkg = filepath.Dir(pkg)
+	}
+}
+
+// moduleVisibility returns the parsed visibility rules that apply to a module, and the position
+// where they were declared.  A nil list of rules means the module is public.
+func (c *Context) moduleVisibility(module *moduleInfo) ([]visibilityRule, scanner.Position, error) {
+	var rules []string
+	var pos scanner.Position
+
+	if v, ok := module.logicModule.(VisibilityModule); ok {
+		rules = v.Visibility()
+		pos = module.propertyPos["visibility"]
+		if !pos.IsValid() {
+			pos = module.pos
+		}
+	}
+
+	if rules == nil {
+		if v := c.defaultVisibility(module.pkg()); v != nil {
+			rules, pos = v.rules, v.pos
+		}
+	}
+
+	if rules == nil {
+		return nil, pos, nil
+	}
+
+	parsed, err := parseVisibilityRules(rules, module.pkg())
+	return parsed, pos, err
+}
+
+// checkVisibility reports an error for every dependency on a module that is not visible to the
+// depending module.
+func (c *Context) checkVisibility() (errs []error) {
+	type visibility struct {
+		rules []visibilityRule
+		pos   scanner.Position
+		err   error
+	}
+	cache := make(map[*moduleInfo]*visibility)
+
+	lookup := func(module *moduleInfo) *visibility {
+		if v, ok := cache[module]; ok {
+			return v
+		}
+		v := &visibility{}
+		v.rules, v.pos, v.err = c.moduleVisibility(module)
+		cache[module] = v
+		if v.err != nil {
+			errs = append(errs, &PropertyError{
+				ModuleError: ModuleError{
+					BlueprintError: BlueprintError{
+						Err: v.err,
+						Pos: v.pos,
+					},
+					module: module,
+				},
+				property: "visibility",
+			})
+		}
+		return v
+	}
+
+	visible := func(rules []visibilityRule, pkg string) bool {
+		if rules == nil {
+			return true
+		}
+		for _, rule := range rules {
+			if rule.matches(pkg) {
+				return true
+			}
+		}
+		return false
+	}
+
+	for _, group := range c.moduleGroups {
+		for _, module := range group.modules {
+			pkg := module.pkg()
+			for _, dep := range module.directDeps {
+				if dep.module.group == module.group {
+					continue
+				}
+
+				v := lookup(dep.module)
+				if v.err != nil || visible(v.rules, pkg) {
+					continue
+				}
+
+				errs = append(errs, &ModuleError{
+					BlueprintError: BlueprintError{
+						// seven characters at the start of the second line to align with the string "error: "
+						Err: fmt.Errorf("depends on %s which is not visible to package %q\n"+
+							"       %s <-- visibility declared here", dep.module, pkg, v.pos),
+						Pos: module.pos,
+					},
+					module: module,
+				})
+
+				if len(errs) > maxErrors {
+					return errs
+				}
+			}
+		}
+	}
+
+	return errs
+}
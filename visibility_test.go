@@ -0,0 +1,227 @@
+// Copyright 2020 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blueprint
+
+import (
+	"testing"
+)
+
+type visibilityTestModule struct {
+	SimpleName
+	SimpleVisibility
+	properties struct {
+		Deps []string
+	}
+}
+
+func newVisibilityTestModule() (Module, []interface{}) {
+	m := &visibilityTestModule{}
+	return m, []interface{}{&m.properties, &m.SimpleName.Properties, &m.SimpleVisibility.Properties}
+}
+
+func (m *visibilityTestModule) GenerateBuildActions(ModuleContext) {
+}
+
+func visibilityTestDepsMutator(ctx BottomUpMutatorContext) {
+	if m, ok := ctx.Module().(*visibilityTestModule); ok {
+		ctx.AddDependency(ctx.Module(), nil, m.properties.Deps...)
+	}
+}
+
+func TestVisibility(t *testing.T) {
+	testCases := []struct {
+		name string
+		fs   map[string][]byte
+		errs []string
+	}{
+		{
+			name: "public by default",
+			fs: map[string][]byte{
+				"Blueprints": []byte(`
+					test {
+						name: "a",
+						deps: ["b"],
+					}
+				`),
+				"lib/Blueprints": []byte(`
+					test {
+						name: "b",
+					}
+				`),
+			},
+		},
+		{
+			name: "private",
+			fs: map[string][]byte{
+				"Blueprints": []byte(`
+					test {
+						name: "a",
+						deps: ["b"],
+					}
+				`),
+				"lib/Blueprints": []byte(`
+					test {
+						name: "b",
+						visibility: ["//visibility:private"],
+					}
+
+					test {
+						name: "c",
+						deps: ["b"],
+					}
+				`),
+			},
+			errs: []string{
+				`Blueprints:2:6: module "a": depends on module "b" which is not visible to package "."` + "\n" +
+					`       lib/Blueprints:4:17 <-- visibility declared here`,
+			},
+		},
+		{
+			name: "subpackages",
+			fs: map[string][]byte{
+				"a/Blueprints": []byte(`
+					test {
+						name: "a",
+						deps: ["c"],
+					}
+				`),
+				"lib/sub/Blueprints": []byte(`
+					test {
+						name: "b",
+						deps: ["c"],
+					}
+				`),
+				"lib/Blueprints": []byte(`
+					test {
+						name: "c",
+						visibility: [":__subpackages__", "//a:__pkg__"],
+					}
+				`),
+				"libfoo/Blueprints": []byte(`
+					test {
+						name: "d",
+						deps: ["c"],
+					}
+				`),
+			},
+			errs: []string{
+				`libfoo/Blueprints:2:6: module "d": depends on module "c" which is not visible to package "libfoo"` + "\n" +
+					`       lib/Blueprints:4:17 <-- visibility declared here`,
+			},
+		},
+		{
+			name: "default visibility",
+			fs: map[string][]byte{
+				"Blueprints": []byte(`
+					test {
+						name: "a",
+						deps: ["b", "c", "d"],
+					}
+				`),
+				"lib/Blueprints": []byte(`
+					default_visibility = ["//visibility:private"]
+
+					test {
+						name: "b",
+					}
+				`),
+				"lib/sub/Blueprints": []byte(`
+					test {
+						name: "c",
+					}
+				`),
+				"lib/public/Blueprints": []byte(`
+					default_visibility = ["//visibility:public"]
+
+					test {
+						name: "d",
+					}
+				`),
+			},
+			errs: []string{
+				`Blueprints:2:6: module "a": depends on module "b" which is not visible to package "."` + "\n" +
+					`       lib/Blueprints:2:25 <-- visibility declared here`,
+				`Blueprints:2:6: module "a": depends on module "c" which is not visible to package "."` + "\n" +
+					`       lib/Blueprints:2:25 <-- visibility declared here`,
+			},
+		},
+		{
+			name: "module overrides default",
+			fs: map[string][]byte{
+				"Blueprints": []byte(`
+					test {
+						name: "a",
+						deps: ["b"],
+					}
+				`),
+				"lib/Blueprints": []byte(`
+					default_visibility = ["//visibility:private"]
+
+					test {
+						name: "b",
+						visibility: ["//:__pkg__"],
+					}
+				`),
+			},
+		},
+		{
+			name: "invalid rules",
+			fs: map[string][]byte{
+				"Blueprints": []byte(`
+					test {
+						name: "a",
+						deps: ["b", "c"],
+					}
+				`),
+				"lib/Blueprints": []byte(`
+					test {
+						name: "b",
+						visibility: ["//visibility:public", "//a:__pkg__"],
+					}
+
+					test {
+						name: "c",
+						visibility: ["//a:b"],
+					}
+				`),
+			},
+			errs: []string{
+				`lib/Blueprints:4:17: module "b": visibility: visibility rule "//visibility:public" may not be combined with other visibility rules`,
+				`lib/Blueprints:9:17: module "c": visibility: invalid visibility rule "//a:b", target must be __pkg__ or __subpackages__`,
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := NewContext()
+			ctx.RegisterModuleType("test", newVisibilityTestModule)
+			ctx.RegisterBottomUpMutator("deps", visibilityTestDepsMutator)
+			ctx.MockFileSystem(tc.fs)
+
+			_, errs := ctx.ParseBlueprintsFiles("Blueprints", nil)
+			if len(errs) > 0 {
+				t.Errorf("unexpected parse errors:")
+				for _, err := range errs {
+					t.Errorf("  %s", err)
+				}
+				t.FailNow()
+			}
+
+			_, errs = ctx.ResolveDependencies(nil)
+			expectedErrors(t, errs, tc.errs...)
+		})
+	}
+}
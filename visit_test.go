@@ -16,6 +16,9 @@ package blueprint
 
 import (
 	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 )
 
@@ -167,3 +170,66 @@ func assertString(t *testing.T, got, expected string) {
 		t.Errorf("expected %q got %q", expected, got)
 	}
 }
+
+func TestShuffleVisitOrder(t *testing.T) {
+	ctx := NewContext()
+	ctx.RegisterModuleType("visit_module", newVisitModule)
+	ctx.RegisterBottomUpMutator("visit_deps", visitDepsMutator)
+
+	bp := ""
+	var names []string
+	for i := 0; i < 20; i++ {
+		name := fmt.Sprintf("M%d", i)
+		names = append(names, fmt.Sprintf("%q", name))
+		bp += fmt.Sprintf("visit_module { name: %q, visit: [\"leaf\"] }\n", name)
+	}
+	bp += "visit_module { name: \"leaf\" }\n"
+	bp += fmt.Sprintf("visit_module { name: \"top\", visit: [%s] }\n", strings.Join(names, ", "))
+	ctx.MockFileSystem(map[string][]byte{
+		"Blueprints": []byte(bp),
+	})
+
+	_, errs := ctx.ParseBlueprintsFiles("Blueprints", nil)
+	if len(errs) == 0 {
+		_, errs = ctx.ResolveDependencies(nil)
+	}
+	if len(errs) > 0 {
+		t.Errorf("unexpected errors:")
+		for _, err := range errs {
+			t.Errorf("  %s", err)
+		}
+		t.FailNow()
+	}
+
+	for _, parallelism := range []int{1, 2} {
+		for seed := int64(0); seed < 3; seed++ {
+			ctx.SetParallelism(parallelism)
+			ctx.SetShuffleVisitOrder(seed)
+
+			var lock sync.Mutex
+			var order []string
+			var running, maxRunning int32
+			ctx.parallelVisit(bottomUpVisitor, func(module *moduleInfo) bool {
+				n := atomic.AddInt32(&running, 1)
+				defer atomic.AddInt32(&running, -1)
+
+				lock.Lock()
+				defer lock.Unlock()
+				if n > maxRunning {
+					maxRunning = n
+				}
+				order = append(order, module.Name())
+				return false
+			})
+
+			if len(order) != 22 || order[0] != "leaf" || order[21] != "top" {
+				t.Errorf("parallelism %d seed %d: expected 22 modules starting with leaf and ending with top, got %q",
+					parallelism, seed, order)
+			}
+			if int(maxRunning) > parallelism {
+				t.Errorf("parallelism %d seed %d: %d modules visited in parallel",
+					parallelism, seed, maxRunning)
+			}
+		}
+	}
+}
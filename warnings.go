@@ -0,0 +1,122 @@
+// Copyright 2020 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blueprint
+
+import (
+	"fmt"
+	"sort"
+	"text/scanner"
+)
+
+// A Warning describes a problem that does not prevent generating the build actions, for example
+// the use of a deprecated property.  Warnings are reported by mutators, modules and singletons
+// through the ModuleWarningf and PropertyWarningf methods of their contexts, and are returned by
+// Context.PrepareBuildActions and Context.Warnings.
+type Warning struct {
+	// Category is a short name for the kind of problem, for example "deprecated".  The primary
+	// builder can use it to decide whether to ignore a warning or treat it as an error.
+	Category string
+
+	// Err describes the problem and where it occurred.  It is a *ModuleError or *PropertyError,
+	// so it can be reported as an error if the warning is promoted.
+	Err error
+}
+
+func (w *Warning) String() string {
+	return fmt.Sprintf("%s [%s]", w.Err, w.Category)
+}
+
+func newModuleWarning(module *moduleInfo, category, format string, args []interface{}) *Warning {
+	return &Warning{
+		Category: category,
+		Err: &ModuleError{
+			BlueprintError: BlueprintError{
+				Err: fmt.Errorf(format, args...),
+				Pos: module.pos,
+			},
+			module: module,
+		},
+	}
+}
+
+func newPropertyWarning(module *moduleInfo, property, category, format string,
+	args []interface{}) *Warning {
+
+	pos := module.propertyPos[property]
+
+	if !pos.IsValid() {
+		pos = module.pos
+	}
+
+	return &Warning{
+		Category: category,
+		Err: &PropertyError{
+			ModuleError: ModuleError{
+				BlueprintError: BlueprintError{
+					Err: fmt.Errorf(format, args...),
+					Pos: pos,
+				},
+				module: module,
+			},
+			property: property,
+		},
+	}
+}
+
+func (w *Warning) pos() scanner.Position {
+	switch err := w.Err.(type) {
+	case *PropertyError:
+		return err.Pos
+	case *ModuleError:
+		return err.Pos
+	case *BlueprintError:
+		return err.Pos
+	}
+	return scanner.Position{}
+}
+
+// Warnings returns the warnings that were reported by mutators during the most recent call to
+// ResolveDependencies, including the one PrepareBuildActions makes when ResolveDependencies
+// wasn't called, and by modules and singletons during the most recent call to
+// PrepareBuildActions, sorted by position.  Running a phase again discards the warnings of its
+// previous run, so warnings are not reported twice.  Identical warnings are only returned once.
+// The same warnings are returned by PrepareBuildActions.
+func (c *Context) Warnings() []*Warning {
+	warnings := append([]*Warning(nil), c.warnings...)
+
+	sort.SliceStable(warnings, func(i, j int) bool {
+		a, b := warnings[i].pos(), warnings[j].pos()
+		if a.Filename != b.Filename {
+			return a.Filename < b.Filename
+		}
+		if a.Line != b.Line {
+			return a.Line < b.Line
+		}
+		if a.Column != b.Column {
+			return a.Column < b.Column
+		}
+		return warnings[i].String() < warnings[j].String()
+	})
+
+	ret := warnings[:0]
+	for i, w := range warnings {
+		if i > 0 && w.String() == warnings[i-1].String() {
+			continue
+		}
+		ret = append(ret, w)
+	}
+
+	return ret
+}
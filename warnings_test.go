@@ -0,0 +1,157 @@
+// Copyright 2020 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blueprint
+
+import (
+	"reflect"
+	"testing"
+)
+
+type warningsTestModule struct {
+	SimpleName
+	properties struct {
+		Old_srcs []string
+	}
+}
+
+func newWarningsTestModule() (Module, []interface{}) {
+	m := &warningsTestModule{}
+	return m, []interface{}{&m.properties, &m.SimpleName.Properties}
+}
+
+func (m *warningsTestModule) GenerateBuildActions(ctx ModuleContext) {
+	if ctx.ModuleName() == "b" {
+		ctx.ModuleWarningf("style", "module %s should be renamed", ctx.ModuleName())
+	}
+}
+
+type warningsTestSingleton struct{}
+
+func (warningsTestSingleton) GenerateBuildActions(ctx SingletonContext) {
+	ctx.VisitAllModules(func(m Module) {
+		if ctx.ModuleName(m) == "a" {
+			// Identical warnings are only returned once.
+			ctx.PropertyWarningf(m, "old_srcs", "singleton", "seen by singleton")
+			ctx.PropertyWarningf(m, "old_srcs", "singleton", "seen by singleton")
+		}
+	})
+}
+
+func newWarningsTestContext() *Context {
+	ctx := NewContext()
+	ctx.RegisterModuleType("test", newWarningsTestModule)
+	ctx.RegisterBottomUpMutator("deprecated", func(ctx BottomUpMutatorContext) {
+		if ctx.ContainsProperty("old_srcs") {
+			ctx.PropertyWarningf("old_srcs", "deprecated", "old_srcs is deprecated, use srcs")
+		}
+	})
+	ctx.RegisterBottomUpMutator("split", func(ctx BottomUpMutatorContext) {
+		ctx.CreateVariations("x", "y")
+	})
+	ctx.RegisterSingletonType("warnings_singleton", func() Singleton { return warningsTestSingleton{} })
+	ctx.MockFileSystem(map[string][]byte{
+		"Blueprints": []byte(`
+			test {
+				name: "b",
+			}
+
+			test {
+				name: "a",
+				old_srcs: ["a.c"],
+			}
+		`),
+	})
+	return ctx
+}
+
+func warningStrings(warnings []*Warning) []string {
+	var ret []string
+	for _, w := range warnings {
+		ret = append(ret, w.String())
+	}
+	return ret
+}
+
+var warningsTestWant = []string{
+	`Blueprints:2:4: module "b" variant "x": module b should be renamed [style]`,
+	`Blueprints:2:4: module "b" variant "y": module b should be renamed [style]`,
+	`Blueprints:8:13: module "a" variant "x": old_srcs: seen by singleton [singleton]`,
+	`Blueprints:8:13: module "a" variant "y": old_srcs: seen by singleton [singleton]`,
+	`Blueprints:8:13: module "a": old_srcs: old_srcs is deprecated, use srcs [deprecated]`,
+}
+
+func TestWarnings(t *testing.T) {
+	ctx := newWarningsTestContext()
+
+	var warnings []*Warning
+	_, errs := ctx.ParseBlueprintsFiles("Blueprints", nil)
+	if len(errs) == 0 {
+		_, warnings, errs = ctx.PrepareBuildActions(nil)
+	}
+	if len(errs) > 0 {
+		t.Errorf("unexpected errors:")
+		for _, err := range errs {
+			t.Errorf("  %s", err)
+		}
+		t.FailNow()
+	}
+
+	if got := warningStrings(warnings); !reflect.DeepEqual(got, warningsTestWant) {
+		t.Errorf("incorrect warnings:\nwant:\n  %q\ngot:\n  %q", warningsTestWant, got)
+	}
+	if got := warningStrings(ctx.Warnings()); !reflect.DeepEqual(got, warningsTestWant) {
+		t.Errorf("incorrect warnings from Warnings:\nwant:\n  %q\ngot:\n  %q", warningsTestWant, got)
+	}
+}
+
+func TestWarningsPerPhase(t *testing.T) {
+	ctx := newWarningsTestContext()
+
+	_, errs := ctx.ParseBlueprintsFiles("Blueprints", nil)
+	if len(errs) == 0 {
+		_, errs = ctx.ResolveDependencies(nil)
+	}
+	if len(errs) > 0 {
+		t.Fatalf("unexpected errors: %q", errs)
+	}
+
+	got := warningStrings(ctx.Warnings())
+	want := []string{
+		`Blueprints:8:13: module "a": old_srcs: old_srcs is deprecated, use srcs [deprecated]`,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("incorrect warnings after ResolveDependencies:\nwant:\n  %q\ngot:\n  %q", want, got)
+	}
+
+	// The warnings of ResolveDependencies are returned along with those of PrepareBuildActions,
+	// and running PrepareBuildActions again replaces its warnings instead of adding to them.
+	for i := 0; i < 2; i++ {
+		_, warnings, errs := ctx.PrepareBuildActions(nil)
+		if len(errs) > 0 {
+			t.Fatalf("unexpected errors: %q", errs)
+		}
+
+		if got := warningStrings(warnings); !reflect.DeepEqual(got, warningsTestWant) {
+			t.Errorf("incorrect warnings from PrepareBuildActions call %d:\nwant:\n  %q\ngot:\n  %q",
+				i+1, warningsTestWant, got)
+		}
+		// The mutator reports one warning, each variant of b reports one, and the singleton reports
+		// two identical ones for each variant of a.
+		if len(ctx.warnings) != 7 {
+			t.Errorf("expected 7 recorded warnings after PrepareBuildActions call %d, got %d",
+				i+1, len(ctx.warnings))
+		}
+	}
+}